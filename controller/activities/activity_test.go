@@ -155,8 +155,12 @@ func TestNewGraphFromDot_InvalidFormat(t *testing.T) {
 
 	graph, err := NewGraphFromDot(dotString)
 
-	assert.NoError(t, err) // Should not error, just ignore invalid lines
-	assert.Empty(t, graph.Nodes)
+	assert.Nil(t, graph)
+	var syntaxErr *DotSyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+	assert.Equal(t, 1, syntaxErr.Line)
+	assert.Equal(t, 1, syntaxErr.Column)
+	assert.EqualError(t, err, `invalid dot graph at line 1, column 1: expected "digraph", got "not"`)
 }
 
 func TestGraph_TopologicalSort_CyclicGraph(t *testing.T) {
@@ -174,25 +178,109 @@ func TestGraph_TopologicalSort_CyclicGraph(t *testing.T) {
 		},
 	}
 
-	levels := graph.TopologicalSort()
+	levels, err := graph.TopologicalSort()
+
+	assert.Nil(t, levels)
+	var cycleErr *CycleError
+	assert.ErrorAs(t, err, &cycleErr)
+	assert.Equal(t, []string{"a", "b", "c", "a"}, cycleErr.Path)
+	assert.EqualError(t, err, "dependency cycle detected: a -> b -> c -> a")
+}
+
+func TestGraph_DetectCycle(t *testing.T) {
+	graph := NewGraph()
+	graph.AddEdge("app", "database")
+	graph.AddEdge("database", "vpc")
+	graph.AddEdge("cache", "vpc")
+	assert.NoError(t, graph.DetectCycle())
+
+	// Only the nodes on the cycle are reported, not the path leading to it
+	graph.AddEdge("vpc", "iam")
+	graph.AddEdge("iam", "database")
+	assert.EqualError(t, graph.DetectCycle(), "dependency cycle detected: database -> vpc -> iam -> database")
+
+	selfLoop := NewGraph()
+	selfLoop.AddEdge("vpc", "vpc")
+	assert.EqualError(t, selfLoop.DetectCycle(), "dependency cycle detected: vpc -> vpc")
+}
+
+func selectionGraph() *Graph {
+	graph := NewGraph()
+	graph.AddEdge("metal/vn-south-1/bootstrap", "metal/vn-south-1/cluster")
+	graph.AddEdge("metal/vn-southwest-1/bootstrap", "metal/vn-southwest-1/cluster")
+	graph.AddEdge("metal/vn-south-1/cluster", "tfstate")
+	graph.AddEdge("metal/vn-southwest-1/cluster", "tfstate")
+	graph.AddNode("oracle/legacy")
+	return graph
+}
 
-	// Should handle cycles gracefully by putting remaining nodes in final level
-	assert.Greater(t, len(levels), 0)
+func TestGraph_Match(t *testing.T) {
+	graph := selectionGraph()
+
+	matched, err := graph.Match([]string{"metal/*/bootstrap", "tfstate", "metal/vn-south-1/bootstrap"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"metal/vn-south-1/bootstrap", "metal/vn-southwest-1/bootstrap", "tfstate"}, matched)
+
+	// Globs do not cross path separators
+	_, err = graph.Match([]string{"*/bootstrap"})
+	assert.EqualError(t, err, `module pattern "*/bootstrap" matches no module`)
+
+	_, err = graph.Match([]string{"metal/[/bootstrap"})
+	assert.ErrorContains(t, err, `invalid module pattern "metal/[/bootstrap"`)
+}
 
-	// All nodes should be present somewhere in the levels
-	allNodes := make(map[string]bool)
-	for _, level := range levels {
-		for _, node := range level {
-			allNodes[node] = true
-		}
+func TestGraph_Select(t *testing.T) {
+	graph := selectionGraph()
+
+	testCases := []struct {
+		name          string
+		nodes         []string
+		include       GraphInclude
+		expectedNodes []string
+		expectedEdges map[string][]string
+	}{
+		{
+			name:          "only the selected modules",
+			nodes:         []string{"metal/vn-south-1/cluster", "tfstate"},
+			include:       IncludeNone,
+			expectedNodes: []string{"metal/vn-south-1/cluster", "tfstate"},
+			expectedEdges: map[string][]string{"metal/vn-south-1/cluster": {"tfstate"}},
+		},
+		{
+			name:          "transitive dependents",
+			nodes:         []string{"metal/vn-south-1/cluster"},
+			include:       IncludeDependents,
+			expectedNodes: []string{"metal/vn-south-1/bootstrap", "metal/vn-south-1/cluster"},
+			expectedEdges: map[string][]string{"metal/vn-south-1/bootstrap": {"metal/vn-south-1/cluster"}},
+		},
+		{
+			name:          "transitive dependencies",
+			nodes:         []string{"metal/vn-south-1/bootstrap", "oracle/legacy"},
+			include:       IncludeDependencies,
+			expectedNodes: []string{"metal/vn-south-1/bootstrap", "metal/vn-south-1/cluster", "oracle/legacy", "tfstate"},
+			expectedEdges: map[string][]string{
+				"metal/vn-south-1/bootstrap": {"metal/vn-south-1/cluster"},
+				"metal/vn-south-1/cluster":   {"tfstate"},
+			},
+		},
 	}
-	assert.True(t, allNodes["a"])
-	assert.True(t, allNodes["b"])
-	assert.True(t, allNodes["c"])
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			selected, err := graph.Select(tc.nodes, tc.include)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedNodes, selected.GetNodes())
+			assert.Equal(t, tc.expectedEdges, selected.Edges)
+		})
+	}
+
+	_, err := graph.Select([]string{"tfstate"}, "both")
+	assert.EqualError(t, err, `unknown include "both", expected one of none, dependents or dependencies`)
 }
 
 func TestExtractQuoted(t *testing.T) {
-	// Test the extractQuoted function indirectly through NewGraphFromDot
+	// Quoted IDs are read without their quotes
 	dotString := `digraph {
 		"hello" -> "world";
 		"test";
@@ -216,6 +304,47 @@ func TestGraph_AddEdge_CreatesNodes(t *testing.T) {
 	assert.Contains(t, graph.Edges["a"], "b")
 }
 
+func TestGraph_DependenciesAndDependents(t *testing.T) {
+	graph := &Graph{
+		Nodes: map[string]bool{
+			"vpc":      true,
+			"database": true,
+			"cache":    true,
+			"app":      true,
+		},
+		Edges: map[string][]string{
+			"database": {"vpc"},
+			"cache":    {"vpc"},
+			"app":      {"database", "cache", "database", "missing"},
+		},
+	}
+
+	assert.Equal(t, []string{"cache", "database"}, graph.Dependencies("app"))
+	assert.Empty(t, graph.Dependencies("vpc"))
+	assert.Equal(t, []string{"cache", "database"}, graph.Dependents("vpc"))
+	assert.Equal(t, []string{"app"}, graph.Dependents("database"))
+	assert.Empty(t, graph.Dependents("app"))
+}
+
+func TestGraph_Subgraph(t *testing.T) {
+	graph := &Graph{
+		Nodes: map[string]bool{
+			"vpc":      true,
+			"database": true,
+			"app":      true,
+		},
+		Edges: map[string][]string{
+			"database": {"vpc"},
+			"app":      {"database"},
+		},
+	}
+
+	subgraph := graph.Subgraph([]string{"app", "database", "missing"})
+
+	assert.ElementsMatch(t, []string{"app", "database"}, subgraph.GetNodes())
+	assert.Equal(t, map[string][]string{"app": {"database"}}, subgraph.Edges)
+}
+
 func TestGraph_GetNodes(t *testing.T) {
 	graph := &Graph{
 		Nodes: map[string]bool{
@@ -234,6 +363,35 @@ func TestGraph_GetNodes(t *testing.T) {
 	assert.Contains(t, nodes, "app")
 }
 
+func TestGraph_DeterministicOrdering(t *testing.T) {
+	graph := NewGraph()
+	graph.AddEdge("app", "database")
+	graph.AddEdge("app", "database")
+	graph.AddEdge("worker", "database")
+	graph.AddEdge("cache", "vpc")
+	graph.AddEdge("database", "vpc")
+	graph.AddNode("dns")
+
+	assert.Equal(t, []string{"database"}, graph.Edges["app"])
+
+	// Map iteration order is randomized, so repeat to catch unstable traversals
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, []string{"app", "cache", "database", "dns", "vpc", "worker"}, graph.GetNodes())
+		levels, err := graph.TopologicalSort()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"dns", "vpc"},
+			{"cache", "database"},
+			{"app", "worker"},
+		}, levels)
+		assert.Equal(t, []string{"app", "worker"}, graph.Dependents("database"))
+
+		pruned, err := PruneGraph(context.Background(), graph, []string{"database"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"app", "database", "worker"}, pruned.GetNodes())
+	}
+}
+
 func TestClone_PathGeneration(t *testing.T) {
 	// Test that generateRepoPath creates deterministic paths
 	url1 := "https://github.com/example/repo.git"
@@ -0,0 +1,339 @@
+package activities
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+// DefaultStateBackupRetention is how many snapshots of a module are kept when no retention is given
+const DefaultStateBackupRetention = 30
+
+// StateSnapshot is a copy of the remote state of a module taken before it was changed
+type StateSnapshot struct {
+	// Key is the object key of the snapshot in the backup location
+	Key     string    `json:"key"`
+	Lineage string    `json:"lineage,omitempty"`
+	Serial  int64     `json:"serial"`
+	Taken   time.Time `json:"taken"`
+	// SnapshotSerial is the serial of the snapshot a restored state was pushed from, Serial is the serial it was
+	// pushed with
+	SnapshotSerial int64 `json:"snapshotSerial,omitempty"`
+}
+
+// stateBackup is an S3-compatible location for state snapshots, s3://<bucket>/<prefix> on the service holding the
+// state of the unit, as configured in its terragrunt configuration: MinIO locally, R2 in production
+type stateBackup struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func openStateBackup(location string, unitDir string) (*stateBackup, error) {
+	bucket, prefix, err := parseStateBackupLocation(location)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInput", err)
+	}
+
+	backend, err := unitBackend(unitDir)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("failed to read the state backend: %s", err), "InvalidInput", err)
+	}
+	service := backupBackend(backend, os.Getenv)
+
+	endpointURL, err := url.Parse(service.Endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("invalid S3 endpoint %q", service.Endpoint), "InvalidInput", err)
+	}
+
+	client, err := minio.New(endpointURL.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(service.AccessKey, service.SecretKey, ""),
+		Secure:       endpointURL.Scheme != "http",
+		Region:       service.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client for %s: %w", service.Endpoint, err)
+	}
+	return &stateBackup{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// parseStateBackupLocation splits s3://<bucket>/<prefix> into the bucket and the prefix without slashes around it
+func parseStateBackupLocation(location string) (string, string, error) {
+	rest, ok := strings.CutPrefix(location, "s3://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return "", "", fmt.Errorf("state backup location %q is not s3://<bucket>/<prefix>", location)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// moduleSnapshotPrefix is the key prefix of every snapshot of a module, with a trailing slash
+func moduleSnapshotPrefix(prefix string, stack string, module string) string {
+	return path.Join(prefix, stack, module) + "/"
+}
+
+// Snapshot keys sort by the time they were taken
+const snapshotTimeLayout = "20060102T150405.000000000Z"
+
+// snapshotKey names a snapshot <prefix>/<stack>/<module>/<time>-<serial>.tfstate
+func snapshotKey(prefix string, stack string, module string, taken time.Time, serial int64) string {
+	return fmt.Sprintf("%s%s-%d.tfstate", moduleSnapshotPrefix(prefix, stack, module), taken.UTC().Format(snapshotTimeLayout), serial)
+}
+
+// parseSnapshotKey reads the time and serial back from a snapshot key, false for other objects
+func parseSnapshotKey(key string) (StateSnapshot, bool) {
+	name, ok := strings.CutSuffix(path.Base(key), ".tfstate")
+	if !ok {
+		return StateSnapshot{}, false
+	}
+	timestamp, serial, ok := strings.Cut(name, "-")
+	if !ok {
+		return StateSnapshot{}, false
+	}
+	taken, err := time.Parse(snapshotTimeLayout, timestamp)
+	if err != nil {
+		return StateSnapshot{}, false
+	}
+	parsedSerial, err := strconv.ParseInt(serial, 10, 64)
+	if err != nil {
+		return StateSnapshot{}, false
+	}
+	return StateSnapshot{Key: key, Serial: parsedSerial, Taken: taken}, true
+}
+
+// expiredSnapshots returns the snapshots beyond the newest retention ones
+func expiredSnapshots(snapshots []StateSnapshot, retention int) []StateSnapshot {
+	if retention <= 0 {
+		retention = DefaultStateBackupRetention
+	}
+	if len(snapshots) <= retention {
+		return nil
+	}
+	sorted := append([]StateSnapshot(nil), snapshots...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted[:len(sorted)-retention]
+}
+
+func (b *stateBackup) list(ctx context.Context, stack string, module string) ([]StateSnapshot, error) {
+	var snapshots []StateSnapshot
+	for object := range b.client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{Prefix: moduleSnapshotPrefix(b.prefix, stack, module)}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list snapshots of module %s: %w", module, object.Err)
+		}
+		if snapshot, ok := parseSnapshotKey(object.Key); ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Key < snapshots[j].Key
+	})
+	return snapshots, nil
+}
+
+func (b *stateBackup) put(ctx context.Context, key string, data []byte, version *stateVersion) error {
+	exists, err := b.client.BucketExists(ctx, b.bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check backup bucket %s: %w", b.bucket, err)
+	}
+	if !exists {
+		if err := b.client.MakeBucket(ctx, b.bucket, minio.MakeBucketOptions{}); err != nil {
+			return fmt.Errorf("failed to create backup bucket %s: %w", b.bucket, err)
+		}
+	}
+
+	_, err = b.client.PutObject(ctx, b.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+		UserMetadata: map[string]string{
+			"lineage": version.Lineage,
+			"serial":  strconv.FormatInt(version.Serial, 10),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload snapshot %s: %w", key, err)
+	}
+	return nil
+}
+
+func (b *stateBackup) get(ctx context.Context, key string) ([]byte, error) {
+	object, err := b.client.GetObject(ctx, b.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download snapshot %s: %w", key, err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download snapshot %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// BackupState uploads the current remote state of a module to the backup location and deletes the snapshots
+// beyond retention. A module without state yet has nothing to back up and returns nil.
+func BackupState(ctx context.Context, repoUrl string, revision string, modulePath string, stack string, location string, retention int) (*StateSnapshot, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Backing up terragrunt state", "module", modulePath, "stack", stack, "location", location)
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	backup, err := openStateBackup(location, fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := runTerragrunt(ctx, fullPath, "state", "pull", "--backend-bootstrap")
+	if err != nil {
+		return nil, classifyTerraformError(fmt.Sprintf("terragrunt state pull failed for module %s", modulePath), err, err.Error(), true)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		logger.Info("No state to back up", "module", modulePath)
+		return nil, nil
+	}
+	version, err := parseStateVersion(modulePath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &StateSnapshot{Lineage: version.Lineage, Serial: version.Serial, Taken: time.Now().UTC()}
+	snapshot.Key = snapshotKey(backup.prefix, stack, modulePath, snapshot.Taken, version.Serial)
+	if err := backup.put(ctx, snapshot.Key, data, version); err != nil {
+		return nil, err
+	}
+	logger.Info("Terragrunt state backed up", "module", modulePath, "key", snapshot.Key, "serial", version.Serial)
+
+	// The snapshot is safe, failing to expire old ones only keeps more of them
+	snapshots, err := backup.list(ctx, stack, modulePath)
+	if err != nil {
+		logger.Warn("Failed to expire state snapshots", "module", modulePath, "error", err)
+		return snapshot, nil
+	}
+	for _, expired := range expiredSnapshots(snapshots, retention) {
+		if err := backup.client.RemoveObject(ctx, backup.bucket, expired.Key, minio.RemoveObjectOptions{}); err != nil {
+			logger.Warn("Failed to expire state snapshot", "module", modulePath, "key", expired.Key, "error", err)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// checkStateSnapshot makes sure a snapshot is an earlier version of the current state: same lineage, serial not
+// ahead. A module without state takes any snapshot.
+func checkStateSnapshot(module string, snapshot *stateVersion, current *stateVersion) error {
+	if current.Lineage == "" {
+		return nil
+	}
+	if snapshot.Lineage != current.Lineage {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("snapshot of module %s has lineage %s, the state has lineage %s", module, snapshot.Lineage, current.Lineage),
+			StateSnapshotError, nil)
+	}
+	if snapshot.Serial > current.Serial {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("snapshot of module %s has serial %d, ahead of the state at serial %d", module, snapshot.Serial, current.Serial),
+			StateSnapshotError, nil)
+	}
+	return nil
+}
+
+// withSerial returns a state document with another serial, keeping every other field as is
+func withSerial(data []byte, serial int64) ([]byte, error) {
+	var state map[string]json.RawMessage
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	state["serial"] = json.RawMessage(strconv.FormatInt(serial, 10))
+	return json.MarshalIndent(state, "", "  ")
+}
+
+// RestoreStateSnapshot pushes a snapshot as the remote state of a module after checking it is an earlier version
+// of the current state. The snapshot is pushed with the serial after the current one, so that terraform accepts
+// it as the next version instead of having to force it, and that serial is the one returned.
+func RestoreStateSnapshot(ctx context.Context, repoUrl string, revision string, modulePath string, stack string, location string, key string) (*StateSnapshot, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Restoring terragrunt state", "module", modulePath, "stack", stack, "key", key)
+
+	_, prefix, err := parseStateBackupLocation(location)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInput", err)
+	}
+	if !strings.HasPrefix(key, moduleSnapshotPrefix(prefix, stack, modulePath)) {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("snapshot %s is not a snapshot of module %s in stack %s", key, modulePath, stack), "InvalidInput", nil)
+	}
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	backup, err := openStateBackup(location, fullPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := backup.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := parseStateVersion(modulePath, data)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), StateSnapshotError, err)
+	}
+
+	current, err := pullStateVersion(ctx, modulePath, fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStateSnapshot(modulePath, snapshot, current); err != nil {
+		return nil, err
+	}
+
+	restored, err := withSerial(data, current.Serial+1)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), StateSnapshotError, err)
+	}
+	dir, err := os.MkdirTemp("", "state-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "terraform.tfstate")
+	if err := os.WriteFile(stateFile, restored, 0o600); err != nil {
+		return nil, err
+	}
+
+	if err := waitForStateLock(ctx, modulePath, func() error {
+		if _, err := runTerragrunt(ctx, fullPath, "state", "push", stateFile); err != nil {
+			return classifyTerraformError(fmt.Sprintf("terragrunt state push failed for module %s", modulePath), err, err.Error(), false)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Terragrunt state restored", "module", modulePath, "key", key, "serial", current.Serial+1)
+	return &StateSnapshot{Key: key, Lineage: snapshot.Lineage, Serial: current.Serial + 1, SnapshotSerial: snapshot.Serial}, nil
+}
@@ -0,0 +1,132 @@
+package activities
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+)
+
+func TestParseStateBackupLocation(t *testing.T) {
+	bucket, prefix, err := parseStateBackupLocation("s3://tfstate-backups/production/")
+	require.NoError(t, err)
+	assert.Equal(t, "tfstate-backups", bucket)
+	assert.Equal(t, "production", prefix)
+
+	bucket, prefix, err = parseStateBackupLocation("s3://tfstate-backups")
+	require.NoError(t, err)
+	assert.Equal(t, "tfstate-backups", bucket)
+	assert.Equal(t, "", prefix)
+
+	for _, invalid := range []string{"", "s3://", "s3:///prefix", "/var/lib/backups", "oci://registry.local/backups"} {
+		_, _, err := parseStateBackupLocation(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSnapshotKey(t *testing.T) {
+	taken := time.Date(2025, 1, 1, 12, 0, 0, 123, time.UTC)
+
+	key := snapshotKey("backups", "production", "metal/cluster", taken, 42)
+	assert.Equal(t, "backups/production/metal/cluster/20250101T120000.000000123Z-42.tfstate", key)
+
+	snapshot, ok := parseSnapshotKey(key)
+	require.True(t, ok)
+	assert.Equal(t, StateSnapshot{Key: key, Serial: 42, Taken: taken}, snapshot)
+
+	for _, other := range []string{"backups/production/metal/cluster/notes.txt", "backups/production/metal/cluster/latest.tfstate", "backups/production/metal/cluster/20250101T120000.000000123Z-x.tfstate"} {
+		_, ok := parseSnapshotKey(other)
+		assert.False(t, ok, other)
+	}
+}
+
+func TestExpiredSnapshots(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var snapshots []StateSnapshot
+	for i := 4; i >= 0; i-- {
+		taken := start.Add(time.Duration(i) * time.Hour)
+		snapshots = append(snapshots, StateSnapshot{Key: snapshotKey("", "dev", "cluster", taken, int64(i)), Serial: int64(i), Taken: taken})
+	}
+
+	expired := expiredSnapshots(snapshots, 2)
+	require.Len(t, expired, 3)
+	for i, snapshot := range expired {
+		assert.Equal(t, int64(i), snapshot.Serial)
+	}
+
+	assert.Empty(t, expiredSnapshots(snapshots, 5))
+	// Without retention the default applies
+	assert.Empty(t, expiredSnapshots(snapshots, 0))
+}
+
+func TestCheckStateSnapshot(t *testing.T) {
+	current := &stateVersion{Lineage: "b6e1c6a4", Serial: 10}
+
+	testCases := []struct {
+		name     string
+		snapshot *stateVersion
+		current  *stateVersion
+		expected string
+	}{
+		{
+			name:     "earlier version",
+			snapshot: &stateVersion{Lineage: "b6e1c6a4", Serial: 7},
+			current:  current,
+		},
+		{
+			name:     "module without state",
+			snapshot: &stateVersion{Lineage: "b6e1c6a4", Serial: 7},
+			current:  &stateVersion{},
+		},
+		{
+			name:     "other lineage",
+			snapshot: &stateVersion{Lineage: "0f3d2e1c", Serial: 7},
+			current:  current,
+			expected: "snapshot of module cluster has lineage 0f3d2e1c, the state has lineage b6e1c6a4",
+		},
+		{
+			name:     "ahead of the state",
+			snapshot: &stateVersion{Lineage: "b6e1c6a4", Serial: 11},
+			current:  current,
+			expected: "snapshot of module cluster has serial 11, ahead of the state at serial 10",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkStateSnapshot("cluster", tc.snapshot, tc.current)
+
+			if tc.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.expected)
+			var appErr *temporal.ApplicationError
+			require.ErrorAs(t, err, &appErr)
+			assert.Equal(t, StateSnapshotError, appErr.Type())
+			assert.True(t, appErr.NonRetryable())
+		})
+	}
+}
+
+func TestWithSerial(t *testing.T) {
+	data := []byte(`{"version": 4, "serial": 7, "lineage": "b6e1c6a4", "outputs": {"name": {"value": "cluster", "type": "string"}}, "resources": []}`)
+
+	restored, err := withSerial(data, 11)
+	require.NoError(t, err)
+
+	version, err := parseStateVersion("cluster", restored)
+	require.NoError(t, err)
+	assert.Equal(t, &stateVersion{Lineage: "b6e1c6a4", Serial: 11}, version)
+
+	var state map[string]any
+	require.NoError(t, json.Unmarshal(restored, &state))
+	assert.Equal(t, map[string]any{"name": map[string]any{"value": "cluster", "type": "string"}}, state["outputs"])
+	assert.Equal(t, []any{}, state["resources"])
+
+	_, err = withSerial([]byte("not a state"), 11)
+	assert.Error(t, err)
+}
@@ -0,0 +1,578 @@
+package activities
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DotSyntaxError reports where a DOT graph stopped making sense, lines and columns start at 1 and columns count bytes
+type DotSyntaxError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *DotSyntaxError) Error() string {
+	return fmt.Sprintf("invalid dot graph at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+type dotTokenKind int
+
+const (
+	dotEOF dotTokenKind = iota
+	dotID
+	dotLBrace
+	dotRBrace
+	dotLBracket
+	dotRBracket
+	dotSemicolon
+	dotComma
+	dotEqual
+	dotColon
+	dotPlus
+	dotArrow
+	dotUndirected
+)
+
+type dotToken struct {
+	kind dotTokenKind
+	text string
+	// quoted IDs are never keywords and are the only ones that can be concatenated with +
+	quoted bool
+	line   int
+	column int
+}
+
+func (t dotToken) String() string {
+	if t.kind == dotEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// keyword reports whether a token is the unquoted keyword, DOT keywords are case insensitive
+func (t dotToken) keyword(name string) bool {
+	return t.kind == dotID && !t.quoted && strings.EqualFold(t.text, name)
+}
+
+func (t dotToken) isKeyword() bool {
+	for _, name := range []string{"strict", "graph", "digraph", "subgraph", "node", "edge"} {
+		if t.keyword(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// dotLexer splits the subset of DOT produced by terragrunt and graphviz into tokens: quoted, unquoted, numeral
+// and HTML IDs, punctuation, edge operators, C and C++ comments and # preprocessor lines
+type dotLexer struct {
+	input  string
+	offset int
+	line   int
+	column int
+	// lineStart is true while only whitespace was read since the last newline
+	lineStart bool
+}
+
+func lexDot(input string) ([]dotToken, error) {
+	l := &dotLexer{input: input, line: 1, column: 1, lineStart: true}
+	var tokens []dotToken
+	for {
+		token, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		if token.kind == dotEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *dotLexer) errorf(line int, column int, format string, args ...any) error {
+	return &DotSyntaxError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (l *dotLexer) peek(n int) byte {
+	if l.offset+n < len(l.input) {
+		return l.input[l.offset+n]
+	}
+	return 0
+}
+
+func (l *dotLexer) advance() byte {
+	c := l.input[l.offset]
+	l.offset++
+	if c == '\n' {
+		l.line++
+		l.column = 1
+		l.lineStart = true
+	} else {
+		l.column++
+	}
+	return c
+}
+
+func (l *dotLexer) skipSpaceAndComments() error {
+	for l.offset < len(l.input) {
+		c := l.peek(0)
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v':
+			l.advance()
+		case c == '#' && l.lineStart:
+			for l.offset < len(l.input) && l.peek(0) != '\n' {
+				l.advance()
+			}
+		case c == '/' && l.peek(1) == '/':
+			for l.offset < len(l.input) && l.peek(0) != '\n' {
+				l.advance()
+			}
+		case c == '/' && l.peek(1) == '*':
+			line, column := l.line, l.column
+			l.advance()
+			l.advance()
+			for !(l.peek(0) == '*' && l.peek(1) == '/') {
+				if l.offset >= len(l.input) {
+					return l.errorf(line, column, "unterminated comment")
+				}
+				l.advance()
+			}
+			l.advance()
+			l.advance()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+var dotPunctuation = map[byte]dotTokenKind{
+	'{': dotLBrace,
+	'}': dotRBrace,
+	'[': dotLBracket,
+	']': dotRBracket,
+	';': dotSemicolon,
+	',': dotComma,
+	'=': dotEqual,
+	':': dotColon,
+	'+': dotPlus,
+}
+
+func isDotLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isDotDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *dotLexer) next() (dotToken, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return dotToken{}, err
+	}
+
+	token := dotToken{line: l.line, column: l.column}
+	l.lineStart = false
+	if l.offset >= len(l.input) {
+		token.kind = dotEOF
+		return token, nil
+	}
+
+	c := l.peek(0)
+	switch {
+	case dotPunctuation[c] != 0:
+		token.kind = dotPunctuation[c]
+		token.text = string(l.advance())
+	case c == '-' && l.peek(1) == '>':
+		token.kind = dotArrow
+		token.text = l.input[l.offset : l.offset+2]
+		l.advance()
+		l.advance()
+	case c == '-' && l.peek(1) == '-':
+		token.kind = dotUndirected
+		token.text = l.input[l.offset : l.offset+2]
+		l.advance()
+		l.advance()
+	case c == '"':
+		text, err := l.quoted()
+		if err != nil {
+			return dotToken{}, err
+		}
+		token.kind = dotID
+		token.text = text
+		token.quoted = true
+	case c == '<':
+		text, err := l.html()
+		if err != nil {
+			return dotToken{}, err
+		}
+		token.kind = dotID
+		token.text = text
+	case isDotLetter(c):
+		start := l.offset
+		for l.offset < len(l.input) && (isDotLetter(l.peek(0)) || isDotDigit(l.peek(0))) {
+			l.advance()
+		}
+		token.kind = dotID
+		token.text = l.input[start:l.offset]
+	case isDotDigit(c) || c == '.' || c == '-':
+		text, ok := l.numeral()
+		if !ok {
+			return dotToken{}, l.errorf(token.line, token.column, "unexpected character %q", c)
+		}
+		token.kind = dotID
+		token.text = text
+	default:
+		return dotToken{}, l.errorf(token.line, token.column, "unexpected character %q", c)
+	}
+
+	return token, nil
+}
+
+// quoted reads a double quoted ID. Like graphviz, only \" is an escape and a backslash before a newline continues the
+// line, \\ is also unescaped so that IDs written by dotQuote read back unchanged
+func (l *dotLexer) quoted() (string, error) {
+	line, column := l.line, l.column
+	l.advance()
+
+	var b strings.Builder
+	for {
+		if l.offset >= len(l.input) {
+			return "", l.errorf(line, column, "unterminated string")
+		}
+		c := l.advance()
+		switch {
+		case c == '"':
+			return b.String(), nil
+		case c == '\\' && (l.peek(0) == '"' || l.peek(0) == '\\'):
+			b.WriteByte(l.advance())
+		case c == '\\' && l.peek(0) == '\n':
+			l.advance()
+		case c == '\\' && l.peek(0) == '\r' && l.peek(1) == '\n':
+			l.advance()
+			l.advance()
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// html reads an HTML ID, which is delimited by balanced angle brackets
+func (l *dotLexer) html() (string, error) {
+	line, column := l.line, l.column
+	l.advance()
+
+	start := l.offset
+	depth := 1
+	for {
+		if l.offset >= len(l.input) {
+			return "", l.errorf(line, column, "unterminated HTML string")
+		}
+		switch l.advance() {
+		case '<':
+			depth++
+		case '>':
+			depth--
+			if depth == 0 {
+				return l.input[start : l.offset-1], nil
+			}
+		}
+	}
+}
+
+// numeral reads [-]?(.[0-9]+ | [0-9]+(.[0-9]*)?)
+func (l *dotLexer) numeral() (string, bool) {
+	start := l.offset
+	n := 0
+	if l.peek(n) == '-' {
+		n++
+	}
+	digits := 0
+	for isDotDigit(l.peek(n)) {
+		n++
+		digits++
+	}
+	if l.peek(n) == '.' {
+		n++
+		for isDotDigit(l.peek(n)) {
+			n++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return "", false
+	}
+
+	for range n {
+		l.advance()
+	}
+	return l.input[start:l.offset], true
+}
+
+// dotParser builds a Graph from tokens. Attributes are read and dropped, edges between subgraphs connect every
+// node of one side to every node of the other, like graphviz does.
+type dotParser struct {
+	tokens []dotToken
+	pos    int
+	graph  *Graph
+}
+
+func (p *dotParser) token() dotToken {
+	return p.tokens[p.pos]
+}
+
+func (p *dotParser) advance() dotToken {
+	token := p.tokens[p.pos]
+	if token.kind != dotEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *dotParser) errorf(token dotToken, format string, args ...any) error {
+	return &DotSyntaxError{Line: token.line, Column: token.column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *dotParser) expect(kind dotTokenKind, want string) error {
+	if p.token().kind != kind {
+		return p.errorf(p.token(), "expected %s, got %s", want, p.token())
+	}
+	p.advance()
+	return nil
+}
+
+// parseGraph reads [strict] digraph [ID] { stmt_list }, undirected graphs have no dependency direction
+func (p *dotParser) parseGraph() error {
+	if p.token().keyword("strict") {
+		p.advance()
+	}
+	if p.token().keyword("graph") {
+		return p.errorf(p.token(), "undirected graphs are not supported, expected \"digraph\"")
+	}
+	if !p.token().keyword("digraph") {
+		return p.errorf(p.token(), "expected \"digraph\", got %s", p.token())
+	}
+	p.advance()
+
+	if p.token().kind == dotID && !p.token().isKeyword() {
+		if _, err := p.parseID(); err != nil {
+			return err
+		}
+	}
+	if err := p.expect(dotLBrace, `"{"`); err != nil {
+		return err
+	}
+	if _, err := p.parseStmtList(); err != nil {
+		return err
+	}
+	if err := p.expect(dotRBrace, `"}"`); err != nil {
+		return err
+	}
+	if p.token().kind != dotEOF {
+		return p.errorf(p.token(), "unexpected %s after the end of the graph", p.token())
+	}
+	return nil
+}
+
+// parseStmtList reads statements up to the closing brace and returns the nodes they mention
+func (p *dotParser) parseStmtList() ([]string, error) {
+	var nodes []string
+	for p.token().kind != dotRBrace && p.token().kind != dotEOF {
+		stmtNodes, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, stmtNodes...)
+		if p.token().kind == dotSemicolon {
+			p.advance()
+		}
+	}
+	return nodes, nil
+}
+
+func (p *dotParser) parseStmt() ([]string, error) {
+	token := p.token()
+	switch {
+	case token.keyword("graph") || token.keyword("node") || token.keyword("edge"):
+		p.advance()
+		if p.token().kind != dotLBracket {
+			return nil, p.errorf(p.token(), "expected \"[\" after %q, got %s", token.text, p.token())
+		}
+		return nil, p.parseAttrLists()
+	case token.keyword("subgraph") || token.kind == dotLBrace:
+		nodes, err := p.parseSubgraph()
+		if err != nil {
+			return nil, err
+		}
+		return p.parseEdges(nodes)
+	case token.kind == dotID && !token.isKeyword():
+		if p.tokens[p.pos+1].kind == dotEqual {
+			// Graph attribute like rankdir = LR
+			p.advance()
+			p.advance()
+			if err := p.expectID(); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+		node, err := p.parseNodeID()
+		if err != nil {
+			return nil, err
+		}
+		p.graph.AddNode(node)
+		return p.parseEdges([]string{node})
+	default:
+		return nil, p.errorf(token, "expected a statement, got %s", token)
+	}
+}
+
+// parseEdges reads the right hand side of an edge chain starting from nodes, if any, and the attribute lists after it
+func (p *dotParser) parseEdges(nodes []string) ([]string, error) {
+	mentioned := nodes
+	for p.token().kind == dotArrow || p.token().kind == dotUndirected {
+		if p.token().kind == dotUndirected {
+			return nil, p.errorf(p.token(), "undirected edge \"--\" in a digraph, expected \"->\"")
+		}
+		p.advance()
+
+		var next []string
+		if p.token().keyword("subgraph") || p.token().kind == dotLBrace {
+			subgraphNodes, err := p.parseSubgraph()
+			if err != nil {
+				return nil, err
+			}
+			next = subgraphNodes
+		} else if p.token().kind == dotID && !p.token().isKeyword() {
+			node, err := p.parseNodeID()
+			if err != nil {
+				return nil, err
+			}
+			p.graph.AddNode(node)
+			next = []string{node}
+		} else {
+			return nil, p.errorf(p.token(), "expected a node or subgraph after \"->\", got %s", p.token())
+		}
+
+		for _, src := range nodes {
+			for _, dest := range next {
+				p.graph.AddEdge(src, dest)
+			}
+		}
+		mentioned = append(mentioned, next...)
+		nodes = next
+	}
+
+	if p.token().kind == dotLBracket {
+		if err := p.parseAttrLists(); err != nil {
+			return nil, err
+		}
+	}
+	return mentioned, nil
+}
+
+// parseSubgraph reads [subgraph [ID]] { stmt_list } and returns the nodes it mentions
+func (p *dotParser) parseSubgraph() ([]string, error) {
+	if p.token().keyword("subgraph") {
+		p.advance()
+		if p.token().kind == dotID && !p.token().isKeyword() {
+			if _, err := p.parseID(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.expect(dotLBrace, `"{"`); err != nil {
+		return nil, err
+	}
+	nodes, err := p.parseStmtList()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(dotRBrace, `"}"`); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// parseNodeID reads ID [: port [: compass]], ports do not matter for dependencies
+func (p *dotParser) parseNodeID() (string, error) {
+	node, err := p.parseID()
+	if err != nil {
+		return "", err
+	}
+	for range 2 {
+		if p.token().kind != dotColon {
+			break
+		}
+		p.advance()
+		if err := p.expectID(); err != nil {
+			return "", err
+		}
+	}
+	return node, nil
+}
+
+// parseAttrLists reads one or more [ a = b, c = d ] lists
+func (p *dotParser) parseAttrLists() error {
+	for p.token().kind == dotLBracket {
+		p.advance()
+		for p.token().kind != dotRBracket {
+			if err := p.expectID(); err != nil {
+				return err
+			}
+			if p.token().kind == dotEqual {
+				p.advance()
+				if err := p.expectID(); err != nil {
+					return err
+				}
+			}
+			if p.token().kind == dotComma || p.token().kind == dotSemicolon {
+				p.advance()
+			}
+		}
+		p.advance()
+	}
+	return nil
+}
+
+// parseID reads an ID, quoted IDs can be concatenated like "a" + "b"
+func (p *dotParser) parseID() (string, error) {
+	token := p.token()
+	if token.kind != dotID || token.isKeyword() {
+		return "", p.errorf(token, "expected an ID, got %s", token)
+	}
+	p.advance()
+
+	id := token.text
+	for token.quoted && p.token().kind == dotPlus {
+		p.advance()
+		token = p.token()
+		if token.kind != dotID || !token.quoted {
+			return "", p.errorf(token, "expected a quoted string after \"+\", got %s", token)
+		}
+		p.advance()
+		id += token.text
+	}
+	return id, nil
+}
+
+func (p *dotParser) expectID() error {
+	_, err := p.parseID()
+	return err
+}
+
+// NewGraphFromDot parses the output of terragrunt dag graph, or any graphviz digraph, edges point from a module
+// to its dependencies
+func NewGraphFromDot(dot string) (*Graph, error) {
+	tokens, err := lexDot(dot)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &dotParser{tokens: tokens, graph: NewGraph()}
+	if err := parser.parseGraph(); err != nil {
+		return nil, err
+	}
+
+	return parser.graph, nil
+}
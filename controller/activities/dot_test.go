@@ -0,0 +1,200 @@
+package activities
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGraphFromDot(t *testing.T) {
+	testCases := []struct {
+		name          string
+		dot           string
+		expectedNodes []string
+		expectedEdges map[string][]string
+	}{
+		{
+			name:          "terragrunt output",
+			dot:           "digraph {\n\t\"app\" ;\n\t\"app\" -> \"vpc\";\n\t\"vpc\" ;\n}\n",
+			expectedNodes: []string{"app", "vpc"},
+			expectedEdges: map[string][]string{"app": {"vpc"}},
+		},
+		{
+			name:          "edge chain",
+			dot:           `digraph { "a" -> "b" -> "c" }`,
+			expectedNodes: []string{"a", "b", "c"},
+			expectedEdges: map[string][]string{"a": {"b"}, "b": {"c"}},
+		},
+		{
+			name:          "attribute lists",
+			dot:           `digraph { a [color=red, label="A"][shape=box]; a -> b [style=dashed; weight=2] }`,
+			expectedNodes: []string{"a", "b"},
+			expectedEdges: map[string][]string{"a": {"b"}},
+		},
+		{
+			name:          "unquoted and numeral IDs",
+			dot:           `digraph G { app_1 -> -1.5 -> .5 -> 42 }`,
+			expectedNodes: []string{"-1.5", ".5", "42", "app_1"},
+			expectedEdges: map[string][]string{"app_1": {"-1.5"}, "-1.5": {".5"}, ".5": {"42"}},
+		},
+		{
+			name:          "escaped quotes and concatenation",
+			dot:           `digraph { "say \"hi\"" -> "back\\slash"; "multi" + "part" }`,
+			expectedNodes: []string{`back\slash`, "multipart", `say "hi"`},
+			expectedEdges: map[string][]string{`say "hi"`: {`back\slash`}},
+		},
+		{
+			name:          "multiple statements per line",
+			dot:           `digraph { "a" -> "b"; "c"; "d" -> "a" }`,
+			expectedNodes: []string{"a", "b", "c", "d"},
+			expectedEdges: map[string][]string{"a": {"b"}, "d": {"a"}},
+		},
+		{
+			name:          "subgraphs",
+			dot:           `digraph { subgraph cluster_region { "x"; "y" } { "a" "b" } -> "c"; "d" -> subgraph { "e"; "f" } }`,
+			expectedNodes: []string{"a", "b", "c", "d", "e", "f", "x", "y"},
+			expectedEdges: map[string][]string{"a": {"c"}, "b": {"c"}, "d": {"e", "f"}},
+		},
+		{
+			name: "graph attributes, defaults, ports and comments",
+			dot: `# generated
+strict digraph "deps" {
+	// defaults
+	rankdir = LR;
+	graph [splines=ortho]
+	node [shape=box]
+	edge [color="#ccc"]
+	/* a
+	   block */
+	"app":out:e -> "db":in;
+	label = <<b>deps</b>>
+}`,
+			expectedNodes: []string{"app", "db"},
+			expectedEdges: map[string][]string{"app": {"db"}},
+		},
+		{
+			name:          "case insensitive keywords",
+			dot:           `DiGraph { Node [shape=box]; "a" }`,
+			expectedNodes: []string{"a"},
+			expectedEdges: map[string][]string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			graph, err := NewGraphFromDot(tc.dot)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedNodes, graph.GetNodes())
+			assert.Equal(t, tc.expectedEdges, graph.Edges)
+		})
+	}
+}
+
+func TestNewGraphFromDot_SyntaxErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dot      string
+		expected string
+	}{
+		{
+			name:     "empty input",
+			dot:      "",
+			expected: `invalid dot graph at line 1, column 1: expected "digraph", got end of input`,
+		},
+		{
+			name:     "undirected graph",
+			dot:      "graph { a -- b }",
+			expected: `invalid dot graph at line 1, column 1: undirected graphs are not supported, expected "digraph"`,
+		},
+		{
+			name:     "undirected edge",
+			dot:      "digraph {\n\ta -- b\n}",
+			expected: `invalid dot graph at line 2, column 4: undirected edge "--" in a digraph, expected "->"`,
+		},
+		{
+			name:     "missing closing brace",
+			dot:      "digraph {\n\t\"a\" -> \"b\";\n",
+			expected: `invalid dot graph at line 3, column 1: expected "}", got end of input`,
+		},
+		{
+			name:     "dangling edge",
+			dot:      "digraph {\n\t\"a\" -> ;\n}",
+			expected: `invalid dot graph at line 2, column 9: expected a node or subgraph after "->", got ";"`,
+		},
+		{
+			name:     "unterminated string",
+			dot:      "digraph {\n\t\"a\" -> \"b\n}",
+			expected: `invalid dot graph at line 2, column 9: unterminated string`,
+		},
+		{
+			name:     "unterminated comment",
+			dot:      "digraph { /* a }",
+			expected: `invalid dot graph at line 1, column 11: unterminated comment`,
+		},
+		{
+			name:     "unclosed attribute list",
+			dot:      `digraph { "a" [color=red }`,
+			expected: `invalid dot graph at line 1, column 26: expected an ID, got "}"`,
+		},
+		{
+			name:     "unexpected character",
+			dot:      "digraph {\n\t\"a\" -> \"b\";\n\t\"c\" @\n}",
+			expected: `invalid dot graph at line 3, column 6: unexpected character '@'`,
+		},
+		{
+			name:     "trailing content",
+			dot:      `digraph { } digraph { }`,
+			expected: `invalid dot graph at line 1, column 13: unexpected "digraph" after the end of the graph`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			graph, err := NewGraphFromDot(tc.dot)
+
+			assert.Nil(t, graph)
+			assert.EqualError(t, err, tc.expected)
+		})
+	}
+}
+
+func TestNewGraphFromDot_RenderDotRoundTrip(t *testing.T) {
+	graph, marks := renderGraph()
+	graph.AddEdge(`metal/"quoted"`, `back\slash`)
+
+	parsed, err := NewGraphFromDot(RenderDot(graph, marks))
+
+	require.NoError(t, err)
+	assert.Equal(t, graph.GetNodes(), parsed.GetNodes())
+	assert.Equal(t, graph.Edges, parsed.Edges)
+}
+
+func FuzzNewGraphFromDot(f *testing.F) {
+	f.Add(simpleDot)
+	f.Add(realWorldDot)
+	f.Add(`digraph { a -> b -> c [color=red]; subgraph { "d" "e" } -> "f\"g" }`)
+	f.Add(RenderDot(renderGraph()))
+
+	f.Fuzz(func(t *testing.T, dot string) {
+		graph, err := NewGraphFromDot(dot)
+		if err != nil {
+			var syntaxErr *DotSyntaxError
+			if !assert.ErrorAs(t, err, &syntaxErr) || syntaxErr.Line < 1 || syntaxErr.Column < 1 {
+				t.Fatalf("Expected a syntax error with a position, got %v", err)
+			}
+			return
+		}
+
+		// Whatever was parsed renders to DOT that parses back to the same graph
+		rendered := RenderDot(graph, nil)
+		parsed, err := NewGraphFromDot(rendered)
+		if err != nil {
+			t.Fatalf("Failed to parse rendered graph %q: %v", rendered, err)
+		}
+		if parsedRendered := RenderDot(parsed, nil); parsedRendered != rendered {
+			t.Fatalf("Expected %q after a round trip, got %q", rendered, parsedRendered)
+		}
+	})
+}
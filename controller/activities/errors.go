@@ -0,0 +1,136 @@
+package activities
+
+import (
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// Application error types returned by terragrunt activities
+const (
+	TerraformValidationError   = "TerraformValidationError"
+	TerraformPlanError         = "TerraformPlanError"
+	TerraformProviderAuthError = "TerraformProviderAuthError"
+	TerraformStateLockError    = "TerraformStateLockError"
+	TerraformTransientError    = "TerraformTransientError"
+	DependencyCycleError       = "DependencyCycleError"
+	GraphSyntaxError           = "GraphSyntaxError"
+	PlanStaleError             = "PlanStaleError"
+	StateSnapshotError         = "StateSnapshotError"
+)
+
+// Output fragments are matched case-insensitively, in the order the classes are checked
+var (
+	stateLockPatterns = []string{
+		"error acquiring the state lock",
+		"error locking state",
+		"conditionalcheckfailedexception",
+	}
+	providerAuthPatterns = []string{
+		"invalidaccesskeyid",
+		"signaturedoesnotmatch",
+		"accessdenied",
+		"access denied",
+		"401 unauthorized",
+		"403 forbidden",
+		"status code: 401",
+		"status code: 403",
+		"invalid credentials",
+		"authentication failed",
+		"no valid credential sources",
+		"failed to get shared config profile",
+		"failed to decrypt",
+	}
+	validationPatterns = []string{
+		"unsupported argument",
+		"missing required argument",
+		"unsupported block type",
+		"unsupported attribute",
+		"invalid reference",
+		"reference to undeclared",
+		"invalid expression",
+		"argument or block definition required",
+		"unclosed configuration block",
+		"invalid value for input variable",
+		"invalid value for variable",
+		"no value for required variable",
+		"invalid block definition",
+		"invalid argument name",
+		"invalid multi-line string",
+		"invalid function argument",
+		"call to unknown function",
+		"invalid template interpolation value",
+		"duplicate resource",
+		"module not installed",
+		"parsing hcl",
+	}
+	// Plan diagnostics that come back the same on every run of the same configuration and state
+	planPatterns = []string{
+		"invalid count argument",
+		"invalid for_each argument",
+		"error: cycle:",
+		"inconsistent dependency lock file",
+		"unsupported terraform core version",
+		"incompatible provider version",
+		"provider produced invalid plan",
+	}
+	transientPatterns = []string{
+		"connection reset by peer",
+		"connection refused",
+		"i/o timeout",
+		"tls handshake timeout",
+		"no such host",
+		"temporary failure in name resolution",
+		"context deadline exceeded",
+		"unexpected eof",
+		"too many requests",
+		"rate limit",
+		"throttl",
+		"status code: 429",
+		"status code: 500",
+		"status code: 502",
+		"status code: 503",
+		"status code: 504",
+		"internal server error",
+		"bad gateway",
+		"service unavailable",
+		"gateway timeout",
+		"failed to query available provider packages",
+	}
+)
+
+func containsAny(output string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(output, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyTerraformError turns a failed terragrunt command into a typed application error based on its output.
+// Only recognised failures that cannot succeed on retry are non-retryable, anything else is left to the retry
+// policy. planning is true if the command failed before any resource was changed, only then are plan diagnostics
+// reported as plan errors.
+func classifyTerraformError(message string, err error, output string, planning bool) error {
+	lock := parseStateLock(output)
+	output = strings.ToLower(output)
+
+	switch {
+	case containsAny(output, stateLockPatterns) && lock != nil:
+		return stateLockError(message, err, lock)
+	case containsAny(output, stateLockPatterns):
+		return temporal.NewApplicationErrorWithCause(message, TerraformStateLockError, err)
+	case containsAny(output, providerAuthPatterns):
+		return temporal.NewNonRetryableApplicationError(message, TerraformProviderAuthError, err)
+	case containsAny(output, validationPatterns):
+		return temporal.NewNonRetryableApplicationError(message, TerraformValidationError, err)
+	case planning && containsAny(output, planPatterns):
+		return temporal.NewNonRetryableApplicationError(message, TerraformPlanError, err)
+	case containsAny(output, transientPatterns):
+		return temporal.NewApplicationErrorWithCause(message, TerraformTransientError, err)
+	default:
+		return fmt.Errorf("%s: %w", message, err)
+	}
+}
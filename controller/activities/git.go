@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"go.temporal.io/sdk/activity"
@@ -27,9 +28,23 @@ func hasCorrectRevision(ctx context.Context, path, revision string) bool {
 	return cmd.Run() == nil
 }
 
+// runRepoPath is the checkout of one workflow run, next to the checkout shared by every run of a url and revision
+func runRepoPath(url string, revision string, runID string) string {
+	return generateRepoPath(url, revision) + "-" + runID
+}
+
 func Clone(ctx context.Context, url string, revision string) (string, error) {
+	return cloneTo(ctx, url, revision, generateRepoPath(url, revision))
+}
+
+// CloneForRun checks out a revision in a directory of the calling workflow run. Workflows running next to Infra
+// use it so that their plan files and the removal of their checkout never touch the checkout of an apply.
+func CloneForRun(ctx context.Context, url string, revision string) (string, error) {
+	return cloneTo(ctx, url, revision, runRepoPath(url, revision, activity.GetInfo(ctx).WorkflowExecution.RunID))
+}
+
+func cloneTo(ctx context.Context, url string, revision string, path string) (string, error) {
 	logger := activity.GetLogger(ctx)
-	path := generateRepoPath(url, revision)
 
 	if hasCorrectRevision(ctx, path, revision) {
 		logger.Info("Repository already available", "path", path)
@@ -45,22 +60,61 @@ func Clone(ctx context.Context, url string, revision string) (string, error) {
 
 	cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", "--branch", revision, url, path)
 	if err := cmd.Run(); err != nil {
-		os.RemoveAll(path)
-		return "", fmt.Errorf("failed to clone repository: %w", err)
+		// --branch only accepts branches and tags, commits have to be fetched explicitly
+		logger.Info("Revision is not a branch or tag, fetching it as a commit", "revision", revision)
+		if err := cloneCommit(ctx, url, revision, path); err != nil {
+			os.RemoveAll(path)
+			return "", fmt.Errorf("failed to clone repository: %w", err)
+		}
 	}
 
 	return path, nil
 }
 
+func cloneCommit(ctx context.Context, url string, revision string, path string) error {
+	os.RemoveAll(path)
+
+	for _, args := range [][]string{
+		{"init", "--quiet", path},
+		{"-C", path, "remote", "add", "origin", url},
+		{"-C", path, "fetch", "--depth", "1", "origin", revision},
+		{"-C", path, "checkout", "--quiet", "--detach", "FETCH_HEAD"},
+	} {
+		if output, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+// fetchRevision makes an older revision available in a shallow clone
+func fetchRevision(ctx context.Context, repoPath string, revision string) error {
+	cmd := exec.CommandContext(ctx, "git", "fetch", "origin", revision)
+	cmd.Dir = repoPath
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to fetch old revision %s: %w", revision, err)
+	}
+	return nil
+}
+
+// ChangedModules is the module list of DetectChanges, kept for workflows started before change reasons were recorded
 func ChangedModules(ctx context.Context, repoPath string, oldRevision string) ([]string, error) {
+	changes, err := DetectChanges(ctx, repoPath, oldRevision, "")
+	if err != nil {
+		return nil, err
+	}
+	return changes.Modules, nil
+}
+
+// DetectChanges maps the files changed since oldRevision to the modules of a stack they affect
+func DetectChanges(ctx context.Context, repoPath string, oldRevision string, stack string) (*ChangeSet, error) {
 	logger := activity.GetLogger(ctx)
 
 	// Since we now clone with depth 1, we need to fetch the oldRevision before we can diff against it
 	logger.Info("Fetching old revision for comparison", "oldRevision", oldRevision)
-	fetchCmd := exec.CommandContext(ctx, "git", "fetch", "origin", oldRevision)
-	fetchCmd.Dir = repoPath
-	if err := fetchCmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to fetch old revision %s: %w", oldRevision, err)
+	if err := fetchRevision(ctx, repoPath, oldRevision); err != nil {
+		return nil, err
 	}
 
 	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", oldRevision, "HEAD")
@@ -70,30 +124,34 @@ func ChangedModules(ctx context.Context, repoPath string, oldRevision string) ([
 		return nil, fmt.Errorf("failed to run git diff: %w", err)
 	}
 
-	seen := make(map[string]struct{})
-	var modules []string
+	return changedUnits(repoPath, stack, strings.Fields(string(output)))
+}
+
+// RemovedModules returns the modules of a stack whose terragrunt.hcl exists at oldRevision but not at HEAD.
+// Renames are reported as removals too, the unit at the old path still owns live resources.
+func RemovedModules(ctx context.Context, repoPath string, oldRevision string, stack string) ([]string, error) {
+	if err := fetchRevision(ctx, repoPath, oldRevision); err != nil {
+		return nil, err
+	}
 
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", "--no-renames", "--diff-filter=D", oldRevision, "HEAD", "--", filepath.Join("infra", stack))
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git diff: %w", err)
+	}
+
+	var modules []string
 	for _, file := range strings.Fields(string(output)) {
-		if file == "" {
+		if filepath.Base(file) != "terragrunt.hcl" {
 			continue
 		}
-
-		for dir := filepath.Dir(file); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
-			if _, err := os.Stat(filepath.Join(repoPath, dir, "terragrunt.hcl")); err == nil {
-				// Remove infra/stack prefix to get module path
-				if parts := strings.Split(filepath.ToSlash(dir), "/"); len(parts) >= 3 && parts[0] == "infra" {
-					if module := strings.Join(parts[2:], "/"); module != "" {
-						if _, exists := seen[module]; !exists {
-							modules = append(modules, module)
-							seen[module] = struct{}{}
-						}
-					}
-				}
-				break
-			}
+		if module := unitModule(filepath.Dir(file)); module != "" {
+			modules = append(modules, module)
 		}
 	}
 
+	sort.Strings(modules)
 	return modules, nil
 }
 
@@ -1,12 +1,17 @@
 package activities
 
 import (
+	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestChangedModules(t *testing.T) {
@@ -420,3 +425,90 @@ func TestGenerateRepoPath(t *testing.T) {
 		t.Error("Different revisions should generate different paths")
 	}
 }
+
+func TestRunRepoPath(t *testing.T) {
+	shared := generateRepoPath("/usr/local/src/cloudlab", "master")
+	run1 := runRepoPath("/usr/local/src/cloudlab", "master", "run-1")
+	run2 := runRepoPath("/usr/local/src/cloudlab", "master", "run-2")
+
+	// Every run gets its own checkout, none of them is the shared one
+	assert.NotEqual(t, shared, run1)
+	assert.NotEqual(t, run1, run2)
+	assert.Equal(t, run1, runRepoPath("/usr/local/src/cloudlab", "master", "run-1"))
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	return strings.TrimSpace(string(output))
+}
+
+// originRepo creates a repository with one commit per layout, each layout replaces the infra directory
+func originRepo(t *testing.T, layouts ...map[string]string) (string, []string) {
+	t.Helper()
+	origin := t.TempDir()
+	git(t, origin, "init", "--quiet", "--initial-branch=master")
+
+	var commits []string
+	for _, files := range layouts {
+		require.NoError(t, os.RemoveAll(filepath.Join(origin, "infra")))
+		for path, content := range files {
+			fullPath := filepath.Join(origin, path)
+			require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0o755))
+			require.NoError(t, os.WriteFile(fullPath, []byte(content), 0o644))
+		}
+		git(t, origin, "add", "--all")
+		git(t, origin, "commit", "--quiet", "--allow-empty", "-m", "update")
+		commits = append(commits, git(t, origin, "rev-parse", "HEAD"))
+	}
+
+	return origin, commits
+}
+
+func TestRemovedModules(t *testing.T) {
+	origin, commits := originRepo(t,
+		map[string]string{
+			"infra/local/cluster/terragrunt.hcl":      ``,
+			"infra/local/bootstrap/terragrunt.hcl":    ``,
+			"infra/local/old/cache/terragrunt.hcl":    ``,
+			"infra/production/tfstate/terragrunt.hcl": ``,
+		},
+		map[string]string{
+			"infra/local/cluster/terragrunt.hcl":     ``,
+			"infra/local/platform/terragrunt.hcl":    ``,
+			"infra/local/new/cache/terragrunt.hcl":   ``,
+			"infra/local/bootstrap/variables.tf.bak": ``,
+		},
+	)
+
+	repoPath := filepath.Join(t.TempDir(), "repo")
+	git(t, "", "clone", "--quiet", "--depth", "1", "file://"+origin, repoPath)
+
+	modules, err := RemovedModules(context.Background(), repoPath, commits[0], "local")
+
+	require.NoError(t, err)
+	// A moved unit still owns the resources created from its old path
+	assert.Equal(t, []string{"bootstrap", "old/cache"}, modules)
+}
+
+func TestCloneCommit(t *testing.T) {
+	origin, commits := originRepo(t,
+		map[string]string{"infra/local/cluster/terragrunt.hcl": ``},
+		map[string]string{"infra/local/platform/terragrunt.hcl": ``},
+	)
+
+	path := filepath.Join(t.TempDir(), "repo")
+	require.NoError(t, cloneCommit(context.Background(), "file://"+origin, commits[0], path))
+
+	assert.Equal(t, commits[0], git(t, path, "rev-parse", "HEAD"))
+	assert.FileExists(t, filepath.Join(path, "infra/local/cluster/terragrunt.hcl"))
+	assert.NoFileExists(t, filepath.Join(path, "infra/local/platform/terragrunt.hcl"))
+	assert.True(t, hasCorrectRevision(context.Background(), path, commits[0]))
+}
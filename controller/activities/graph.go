@@ -2,12 +2,20 @@ package activities
 
 import (
 	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
 	"strings"
 )
 
+// Graph is a dependency graph of terragrunt modules, an edge from A to B means A depends on B.
+// Every traversal returns nodes in sorted order so that workflows using the graph stay deterministic on replay.
 type Graph struct {
 	Nodes map[string]bool     `json:"nodes"`
 	Edges map[string][]string `json:"edges"`
+	// Reasons explains why PruneChanges kept each node
+	Reasons map[string]string `json:"reasons,omitempty"`
 }
 
 func NewGraph() *Graph {
@@ -24,21 +32,220 @@ func (g *Graph) AddNode(name string) {
 func (g *Graph) AddEdge(src, dest string) {
 	g.AddNode(src)
 	g.AddNode(dest)
+	for _, existing := range g.Edges[src] {
+		if existing == dest {
+			return
+		}
+	}
 	g.Edges[src] = append(g.Edges[src], dest)
 }
 
+// GetNodes returns all nodes in sorted order
 func (g *Graph) GetNodes() []string {
 	nodes := make([]string, 0, len(g.Nodes))
 	for name := range g.Nodes {
 		nodes = append(nodes, name)
 	}
+	sort.Strings(nodes)
 	return nodes
 }
 
+// sources returns the nodes with outgoing edges in sorted order
+func (g *Graph) sources() []string {
+	sources := make([]string, 0, len(g.Edges))
+	for src := range g.Edges {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// Dependencies returns the sorted, distinct nodes that node depends on
+func (g *Graph) Dependencies(node string) []string {
+	seen := make(map[string]bool)
+	var dependencies []string
+	for _, dest := range g.Edges[node] {
+		if g.Nodes[dest] && !seen[dest] {
+			seen[dest] = true
+			dependencies = append(dependencies, dest)
+		}
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
+
+// Dependents returns the sorted, distinct nodes that directly depend on node
+func (g *Graph) Dependents(node string) []string {
+	var dependents []string
+	for _, src := range g.sources() {
+		if !g.Nodes[src] {
+			continue
+		}
+		for _, dest := range g.Edges[src] {
+			if dest == node {
+				dependents = append(dependents, src)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// Subgraph returns the graph induced by the given nodes, ignoring nodes that are not in the graph
+func (g *Graph) Subgraph(nodes []string) *Graph {
+	subgraph := NewGraph()
+	for _, node := range nodes {
+		if g.Nodes[node] {
+			subgraph.AddNode(node)
+		}
+	}
+	for _, src := range g.sources() {
+		if subgraph.Nodes[src] {
+			for _, dest := range g.Edges[src] {
+				if subgraph.Nodes[dest] {
+					subgraph.AddEdge(src, dest)
+				}
+			}
+		}
+	}
+	return subgraph
+}
+
+// GraphInclude says which related modules Select adds to the selected ones
+type GraphInclude string
+
+const (
+	IncludeNone         GraphInclude = "none"
+	IncludeDependents   GraphInclude = "dependents"
+	IncludeDependencies GraphInclude = "dependencies"
+)
+
+// Match returns the sorted nodes matching any of the patterns, which are module paths or path.Match globs
+// like metal/*/bootstrap. Every pattern has to match at least one node.
+func (g *Graph) Match(patterns []string) ([]string, error) {
+	matched := make(map[string]bool)
+	for _, pattern := range patterns {
+		found := false
+		for _, node := range g.GetNodes() {
+			ok, err := path.Match(pattern, node)
+			if err != nil {
+				return nil, fmt.Errorf("invalid module pattern %q: %w", pattern, err)
+			}
+			if ok {
+				matched[node] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("module pattern %q matches no module", pattern)
+		}
+	}
+
+	nodes := make([]string, 0, len(matched))
+	for node := range matched {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// Select returns the subgraph of nodes, together with their transitive dependents or dependencies
+func (g *Graph) Select(nodes []string, include GraphInclude) (*Graph, error) {
+	var next func(string) []string
+	switch include {
+	case "", IncludeNone:
+	case IncludeDependents:
+		next = g.Dependents
+	case IncludeDependencies:
+		next = g.Dependencies
+	default:
+		return nil, fmt.Errorf("unknown include %q, expected one of none, dependents or dependencies", include)
+	}
+
+	selected := make(map[string]bool)
+	queue := slices.Clone(nodes)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if selected[node] {
+			continue
+		}
+		selected[node] = true
+		if next != nil {
+			queue = append(queue, next(node)...)
+		}
+	}
+
+	keep := make([]string, 0, len(selected))
+	for node := range selected {
+		keep = append(keep, node)
+	}
+	return g.Subgraph(keep), nil
+}
+
+// Reverse returns a copy of the graph with every edge flipped, so dependents come before their dependencies
+func (g *Graph) Reverse() *Graph {
+	reversed := NewGraph()
+	for _, node := range g.GetNodes() {
+		reversed.AddNode(node)
+	}
+	for _, node := range g.GetNodes() {
+		for _, dependency := range g.Dependencies(node) {
+			reversed.AddEdge(dependency, node)
+		}
+	}
+	return reversed
+}
+
+// PruneChanges keeps the changed modules and everything that depends on them, like PruneGraph, and records why each
+// module was kept: the changed file that affects it, or the shortest dependency path to a changed module
+func PruneChanges(ctx context.Context, graph *Graph, changes *ChangeSet) (*Graph, error) {
+	prunedGraph, err := PruneGraph(ctx, graph, changes.Modules)
+	if err != nil {
+		return nil, err
+	}
+
+	prunedGraph.Reasons = make(map[string]string)
+	// via points from a dependent to the kept module it depends on, breadth first so the paths are the shortest
+	via := make(map[string]string)
+	var queue []string
+	for _, module := range prunedGraph.GetNodes() {
+		if slices.Contains(changes.Modules, module) {
+			if file := changes.Files[module]; file != "" {
+				prunedGraph.Reasons[module] = "changed file " + file
+			} else {
+				prunedGraph.Reasons[module] = "changed"
+			}
+			queue = append(queue, module)
+		}
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, dependent := range prunedGraph.Dependents(node) {
+			if _, explained := prunedGraph.Reasons[dependent]; explained {
+				continue
+			}
+			via[dependent] = node
+
+			chain := []string{dependent}
+			for step := dependent; via[step] != ""; step = via[step] {
+				chain = append(chain, via[step])
+			}
+			prunedGraph.Reasons[dependent] = fmt.Sprintf("dependent of %s via %s", chain[len(chain)-1], strings.Join(chain, " -> "))
+			queue = append(queue, dependent)
+		}
+	}
+
+	return prunedGraph, nil
+}
+
 func PruneGraph(ctx context.Context, graph *Graph, changed []string) (*Graph, error) {
 	dependents := make(map[string][]string)
-	for src, dests := range graph.Edges {
-		for _, dest := range dests {
+	for _, src := range graph.sources() {
+		for _, dest := range graph.Edges[src] {
 			dependents[dest] = append(dependents[dest], src)
 		}
 	}
@@ -65,9 +272,9 @@ func PruneGraph(ctx context.Context, graph *Graph, changed []string) (*Graph, er
 	for node := range keep {
 		prunedGraph.AddNode(node)
 	}
-	for src, dests := range graph.Edges {
+	for _, src := range graph.sources() {
 		if keep[src] {
-			for _, dest := range dests {
+			for _, dest := range graph.Edges[src] {
 				if keep[dest] {
 					prunedGraph.AddEdge(src, dest)
 				}
@@ -78,52 +285,61 @@ func PruneGraph(ctx context.Context, graph *Graph, changed []string) (*Graph, er
 	return prunedGraph, nil
 }
 
-func NewGraphFromDot(dot string) (*Graph, error) {
-	graph := NewGraph()
+// CycleError reports a dependency cycle, the path starts and ends with the same node
+type CycleError struct {
+	Path []string
+}
 
-	lines := strings.SplitSeq(dot, "\n")
-	for line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "//") || line == "digraph {" || line == "}" {
-			continue
-		}
+func (e *CycleError) Error() string {
+	return "dependency cycle detected: " + strings.Join(e.Path, " -> ")
+}
 
-		line = strings.TrimSuffix(line, ";")
-		line = strings.TrimSpace(line)
+// DetectCycle returns a *CycleError for the first cycle found when walking nodes in sorted order, or nil
+func (g *Graph) DetectCycle() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
 
-		if strings.Contains(line, "->") {
-			parts := strings.Split(line, "->")
-			if len(parts) == 2 {
-				src := extractQuotedString(strings.TrimSpace(parts[0]))
-				dest := extractQuotedString(strings.TrimSpace(parts[1]))
-				if src != "" && dest != "" {
-					graph.AddEdge(src, dest)
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		state[node] = visiting
+		stack = append(stack, node)
+		for _, dependency := range g.Dependencies(node) {
+			switch state[dependency] {
+			case visiting:
+				for i, stacked := range stack {
+					if stacked == dependency {
+						return append(append([]string{}, stack[i:]...), dependency)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
 				}
-			}
-		} else {
-			// Parse standalone nodes: "C"
-			nodeName := extractQuotedString(line)
-			if nodeName != "" {
-				graph.AddNode(nodeName)
 			}
 		}
+		stack = stack[:len(stack)-1]
+		state[node] = visited
+		return nil
 	}
 
-	return graph, nil
-}
-
-// extractQuotedString extracts the content between quotes from a string like "hello"
-func extractQuotedString(s string) string {
-	s = strings.TrimSpace(s)
-	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
-		return s[1 : len(s)-1]
+	for _, node := range g.GetNodes() {
+		if state[node] == unvisited {
+			if cycle := visit(node); cycle != nil {
+				return &CycleError{Path: cycle}
+			}
+		}
 	}
-	return ""
+	return nil
 }
 
-// TopologicalSort returns modules grouped by dependency levels for parallel execution.
-// Edge from A to B means A depends on B, so B must run before A.
-func (g *Graph) TopologicalSort() [][]string {
+// TopologicalSort returns modules grouped by dependency levels for parallel execution, each level sorted.
+// Edge from A to B means A depends on B, so B must run before A. A *CycleError is returned if the graph has a cycle.
+func (g *Graph) TopologicalSort() ([][]string, error) {
 	// Build adjacency list and in-degree count
 	adjList := make(map[string][]string)
 	inDegree := make(map[string]int)
@@ -136,9 +352,9 @@ func (g *Graph) TopologicalSort() [][]string {
 
 	// Build the graph and calculate in-degrees
 	// Edge from Src to Dest means Src depends on Dest
-	// So Dest should run before Src
-	for src, dests := range g.Edges {
-		for _, dest := range dests {
+	// So Dest should run before Src, dependencies outside the graph are ignored
+	for _, src := range g.GetNodes() {
+		for _, dest := range g.Dependencies(src) {
 			adjList[dest] = append(adjList[dest], src)
 			inDegree[src]++
 		}
@@ -160,18 +376,11 @@ func (g *Graph) TopologicalSort() [][]string {
 				currentLevel = append(currentLevel, nodeName)
 			}
 		}
+		sort.Strings(currentLevel)
 
 		// If no nodes found with in-degree 0, there's a cycle
 		if len(currentLevel) == 0 {
-			// Return remaining nodes as the final level to handle cycles gracefully
-			var cycleNodes []string
-			for nodeName := range remaining {
-				cycleNodes = append(cycleNodes, nodeName)
-			}
-			if len(cycleNodes) > 0 {
-				levels = append(levels, cycleNodes)
-			}
-			break
+			return nil, g.DetectCycle()
 		}
 
 		// Add current level
@@ -188,5 +397,5 @@ func (g *Graph) TopologicalSort() [][]string {
 		}
 	}
 
-	return levels
+	return levels, nil
 }
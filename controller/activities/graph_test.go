@@ -7,8 +7,8 @@ import (
 	"testing"
 )
 
-func TestPruneGraphSimple(t *testing.T) {
-	dot := `
+// Fixtures shared with the DOT parser fuzz tests
+const simpleDot = `
 digraph {
 	"A" -> "B";
 	"B" -> "C";
@@ -18,94 +18,8 @@ digraph {
 	"F";
 }
 `
-	graph, err := NewGraphFromDot(dot)
-	if err != nil {
-		t.Fatalf("Failed to create graph from dot: %v", err)
-	}
 
-	testCases := []struct {
-		name          string
-		changed       []string
-		expectedNodes []string
-		expectedEdges map[string][]string
-	}{
-		{
-			name:          "Prune to C and its dependencies",
-			changed:       []string{"C"},
-			expectedNodes: []string{"A", "B", "C", "D"},
-			expectedEdges: map[string][]string{
-				"A": {"B"},
-				"B": {"C"},
-				"D": {"B"},
-			},
-		},
-		{
-			name:          "Prune to F and its dependencies",
-			changed:       []string{"F"},
-			expectedNodes: []string{"E", "F"},
-			expectedEdges: map[string][]string{
-				"E": {"F"},
-			},
-		},
-		{
-			name:          "Prune to B and its dependencies",
-			changed:       []string{"B"},
-			expectedNodes: []string{"A", "B", "D"},
-			expectedEdges: map[string][]string{
-				"A": {"B"},
-				"D": {"B"},
-			},
-		},
-		{
-			name:          "No nodes changed",
-			changed:       []string{},
-			expectedNodes: []string{},
-			expectedEdges: map[string][]string{},
-		},
-		{
-			name:          "Changed node not in graph",
-			changed:       []string{"Z"},
-			expectedNodes: []string{},
-			expectedEdges: map[string][]string{},
-		},
-		{
-			name:          "Multiple changed nodes",
-			changed:       []string{"C", "F"},
-			expectedNodes: []string{"A", "B", "C", "D", "E", "F"},
-			expectedEdges: map[string][]string{
-				"A": {"B"},
-				"B": {"C"},
-				"D": {"B"},
-				"E": {"F"},
-			},
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			prunedGraph, err := PruneGraph(context.Background(), graph, tc.changed)
-			if err != nil {
-				t.Fatalf("PruneGraph failed: %v", err)
-			}
-
-			prunedNodes := prunedGraph.GetNodes()
-			sort.Strings(prunedNodes)
-			sort.Strings(tc.expectedNodes)
-
-			if !reflect.DeepEqual(prunedNodes, tc.expectedNodes) {
-				t.Errorf("Expected nodes %v, but got %v", tc.expectedNodes, prunedNodes)
-			}
-
-			// Compare edges
-			if !reflect.DeepEqual(prunedGraph.Edges, tc.expectedEdges) {
-				t.Errorf("Expected edges %v, but got %v", tc.expectedEdges, prunedGraph.Edges)
-			}
-		})
-	}
-}
-
-func TestPruneGraphRealWorld(t *testing.T) {
-	realWorldDot := `digraph {
+const realWorldDot = `digraph {
 	"aks-windows-node-exporter" ;
 	"azuresql" ;
 	"azuresql" -> "core";
@@ -252,6 +166,137 @@ func TestPruneGraphRealWorld(t *testing.T) {
 	"xshare/azuresqlusers" -> "xshare/azuresql";
 }
 `
+
+func TestPruneGraphSimple(t *testing.T) {
+	graph, err := NewGraphFromDot(simpleDot)
+	if err != nil {
+		t.Fatalf("Failed to create graph from dot: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		changed       []string
+		expectedNodes []string
+		expectedEdges map[string][]string
+	}{
+		{
+			name:          "Prune to C and its dependencies",
+			changed:       []string{"C"},
+			expectedNodes: []string{"A", "B", "C", "D"},
+			expectedEdges: map[string][]string{
+				"A": {"B"},
+				"B": {"C"},
+				"D": {"B"},
+			},
+		},
+		{
+			name:          "Prune to F and its dependencies",
+			changed:       []string{"F"},
+			expectedNodes: []string{"E", "F"},
+			expectedEdges: map[string][]string{
+				"E": {"F"},
+			},
+		},
+		{
+			name:          "Prune to B and its dependencies",
+			changed:       []string{"B"},
+			expectedNodes: []string{"A", "B", "D"},
+			expectedEdges: map[string][]string{
+				"A": {"B"},
+				"D": {"B"},
+			},
+		},
+		{
+			name:          "No nodes changed",
+			changed:       []string{},
+			expectedNodes: []string{},
+			expectedEdges: map[string][]string{},
+		},
+		{
+			name:          "Changed node not in graph",
+			changed:       []string{"Z"},
+			expectedNodes: []string{},
+			expectedEdges: map[string][]string{},
+		},
+		{
+			name:          "Multiple changed nodes",
+			changed:       []string{"C", "F"},
+			expectedNodes: []string{"A", "B", "C", "D", "E", "F"},
+			expectedEdges: map[string][]string{
+				"A": {"B"},
+				"B": {"C"},
+				"D": {"B"},
+				"E": {"F"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prunedGraph, err := PruneGraph(context.Background(), graph, tc.changed)
+			if err != nil {
+				t.Fatalf("PruneGraph failed: %v", err)
+			}
+
+			prunedNodes := prunedGraph.GetNodes()
+			sort.Strings(prunedNodes)
+			sort.Strings(tc.expectedNodes)
+
+			if !reflect.DeepEqual(prunedNodes, tc.expectedNodes) {
+				t.Errorf("Expected nodes %v, but got %v", tc.expectedNodes, prunedNodes)
+			}
+
+			// Compare edges
+			if !reflect.DeepEqual(prunedGraph.Edges, tc.expectedEdges) {
+				t.Errorf("Expected edges %v, but got %v", tc.expectedEdges, prunedGraph.Edges)
+			}
+		})
+	}
+}
+
+func TestPruneChanges(t *testing.T) {
+	dot := `
+digraph {
+	"A" -> "B";
+	"B" -> "C";
+	"D" -> "B";
+	"D" -> "C";
+	"E" -> "F";
+	"C";
+	"F";
+}
+`
+	graph, err := NewGraphFromDot(dot)
+	if err != nil {
+		t.Fatalf("Failed to create graph from dot: %v", err)
+	}
+
+	changes := &ChangeSet{
+		Modules: []string{"C", "F"},
+		Files:   map[string]string{"C": "infra/local/C/terragrunt.hcl"},
+	}
+	prunedGraph, err := PruneChanges(context.Background(), graph, changes)
+	if err != nil {
+		t.Fatalf("PruneChanges failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"A": "dependent of C via A -> B -> C",
+		"B": "dependent of C via B -> C",
+		"C": "changed file infra/local/C/terragrunt.hcl",
+		"D": "dependent of C via D -> C",
+		"E": "dependent of F via E -> F",
+		"F": "changed",
+	}
+	if !reflect.DeepEqual(prunedGraph.Reasons, expected) {
+		t.Errorf("Expected reasons %v, but got %v", expected, prunedGraph.Reasons)
+	}
+	if graph.Reasons != nil {
+		t.Errorf("Expected the full graph to be left untouched, but got reasons %v", graph.Reasons)
+	}
+}
+
+func TestPruneGraphRealWorld(t *testing.T) {
 	graph, err := NewGraphFromDot(realWorldDot)
 	if err != nil {
 		t.Fatalf("Failed to create graph from real-world DOT: %v", err)
@@ -379,7 +424,10 @@ func TestTopologicalSort(t *testing.T) {
 			}
 
 			// Get topological sort
-			levels := graph.TopologicalSort()
+			levels, err := graph.TopologicalSort()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
 
 			// Verify number of levels
 			if len(levels) != len(tc.expectedLevels) {
@@ -0,0 +1,373 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"go.temporal.io/sdk/temporal"
+)
+
+// HCLGraph builds the same graph as TerragruntGraph by reading the terragrunt configurations under path directly,
+// so it needs neither the terragrunt binary nor an initialised stack
+func HCLGraph(ctx context.Context, path string) (*Graph, error) {
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	units, err := stackUnits(root)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := NewGraph()
+	for _, unit := range units {
+		name, err := filepath.Rel(root, unit)
+		if err != nil {
+			return nil, err
+		}
+		graph.AddNode(filepath.ToSlash(name))
+
+		dependencies, err := unitDependencies(unit)
+		if err != nil {
+			return nil, err
+		}
+		for _, dependency := range dependencies {
+			dependencyName, err := filepath.Rel(root, dependency)
+			if err != nil {
+				return nil, err
+			}
+			graph.AddEdge(filepath.ToSlash(name), filepath.ToSlash(dependencyName))
+		}
+	}
+
+	if err := graph.DetectCycle(); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), DependencyCycleError, err)
+	}
+
+	return graph, nil
+}
+
+// stackUnits returns the absolute directories of every terragrunt unit under root, sorted
+func stackUnits(root string) ([]string, error) {
+	var units []string
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && path != root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && entry.Name() == "terragrunt.hcl" {
+			units = append(units, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover terragrunt units: %w", err)
+	}
+
+	sort.Strings(units)
+	return units, nil
+}
+
+// unitConfig is what a terragrunt configuration file says about dependencies, directories are absolute
+type unitConfig struct {
+	// dependency blocks by label
+	dependency map[string]string
+	// paths of the dependencies block, nil if there is none
+	dependencies []string
+}
+
+// unitDependencies returns the sorted absolute directories a unit depends on, merging included configurations
+// like terragrunt does: dependency blocks are merged by label with the unit winning, the dependencies block
+// of the unit replaces the included one unless the include is deep merged, then the paths are concatenated
+func unitDependencies(unitDir string) ([]string, error) {
+	file := filepath.Join(unitDir, "terragrunt.hcl")
+	body, err := parseHCLFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	unitEval := &unitEvaluator{unitDir: unitDir, configDir: unitDir}
+	unitCtx := unitEval.context(body)
+	unit, err := unitEval.config(file, body, unitCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := unitConfig{dependency: make(map[string]string)}
+	deepMerge := false
+	for _, block := range body.Blocks {
+		if block.Type != "include" {
+			continue
+		}
+		attr, ok := block.Body.Attributes["path"]
+		if !ok {
+			return nil, fmt.Errorf("include block in %s has no path", file)
+		}
+		includePath, err := evalString(attr.Expr, unitCtx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate include path in %s: %w", file, err)
+		}
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(unitDir, includePath)
+		}
+
+		strategy := "shallow"
+		if attr, ok := block.Body.Attributes["merge_strategy"]; ok {
+			if strategy, err = evalString(attr.Expr, unitCtx); err != nil {
+				return nil, fmt.Errorf("failed to evaluate include merge_strategy in %s: %w", file, err)
+			}
+		}
+		if strategy == "no_merge" {
+			continue
+		}
+
+		includeBody, err := parseHCLFile(includePath)
+		if err != nil {
+			return nil, err
+		}
+		includeEval := &unitEvaluator{unitDir: unitDir, configDir: filepath.Dir(includePath)}
+		included, err := includeEval.config(includePath, includeBody, includeEval.context(includeBody))
+		if err != nil {
+			return nil, err
+		}
+
+		for label, dir := range included.dependency {
+			merged.dependency[label] = dir
+		}
+		if strategy == "deep" {
+			deepMerge = true
+			merged.dependencies = append(merged.dependencies, included.dependencies...)
+		} else if included.dependencies != nil {
+			merged.dependencies = included.dependencies
+		}
+	}
+
+	for label, dir := range unit.dependency {
+		merged.dependency[label] = dir
+	}
+	if deepMerge {
+		merged.dependencies = append(merged.dependencies, unit.dependencies...)
+	} else if unit.dependencies != nil {
+		merged.dependencies = unit.dependencies
+	}
+
+	seen := make(map[string]bool)
+	var dependencies []string
+	for _, dir := range merged.dependencies {
+		seen[dir] = true
+	}
+	for _, dir := range merged.dependency {
+		seen[dir] = true
+	}
+	for dir := range seen {
+		if _, err := os.Stat(filepath.Join(dir, "terragrunt.hcl")); err != nil {
+			return nil, fmt.Errorf("dependency %s of %s is not a terragrunt unit", dir, unitDir)
+		}
+		dependencies = append(dependencies, dir)
+	}
+
+	sort.Strings(dependencies)
+	return dependencies, nil
+}
+
+// unitEvaluator evaluates expressions the way terragrunt does for a unit: functions always resolve from the unit
+// directory, even in included files, except get_parent_terragrunt_dir which is the directory of the included file
+type unitEvaluator struct {
+	unitDir   string
+	configDir string
+	// decrypt adds the functions reading secrets, the graph never needs them
+	decrypt bool
+}
+
+// context returns an evaluation context with the terragrunt functions and the locals of body, locals that
+// cannot be evaluated without running anything, like decrypted secrets, are left out
+func (e *unitEvaluator) context(body *hclsyntax.Body) *hcl.EvalContext {
+	ctx := &hcl.EvalContext{
+		Functions: e.functions(),
+		Variables: map[string]cty.Value{"local": cty.EmptyObjectVal},
+	}
+
+	locals := make(map[string]cty.Value)
+	var pending []*hclsyntax.Attribute
+	for _, block := range body.Blocks {
+		if block.Type == "locals" {
+			for _, attr := range block.Body.Attributes {
+				pending = append(pending, attr)
+			}
+		}
+	}
+
+	// Locals can refer to each other in any order, evaluate them until no more can be
+	for progress := true; progress; {
+		progress = false
+		var next []*hclsyntax.Attribute
+		for _, attr := range pending {
+			value, diags := attr.Expr.Value(ctx)
+			if diags.HasErrors() || !value.IsWhollyKnown() {
+				next = append(next, attr)
+				continue
+			}
+			locals[attr.Name] = value
+			ctx.Variables["local"] = cty.ObjectVal(locals)
+			progress = true
+		}
+		pending = next
+	}
+
+	return ctx
+}
+
+// config reads the dependency and dependencies blocks of a configuration file
+func (e *unitEvaluator) config(file string, body *hclsyntax.Body, ctx *hcl.EvalContext) (*unitConfig, error) {
+	config := &unitConfig{dependency: make(map[string]string)}
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "dependency":
+			if len(block.Labels) != 1 {
+				return nil, fmt.Errorf("dependency block in %s must have exactly one label", file)
+			}
+			label := block.Labels[0]
+			if attr, ok := block.Body.Attributes["enabled"]; ok {
+				value, diags := attr.Expr.Value(ctx)
+				if !diags.HasErrors() && value.Type() == cty.Bool && value.IsKnown() && !value.IsNull() && value.False() {
+					continue
+				}
+			}
+			attr, ok := block.Body.Attributes["config_path"]
+			if !ok {
+				return nil, fmt.Errorf("dependency %q in %s has no config_path", label, file)
+			}
+			path, err := evalString(attr.Expr, ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate config_path of dependency %q in %s: %w", label, file, err)
+			}
+			config.dependency[label] = e.dependencyDir(path)
+		case "dependencies":
+			attr, ok := block.Body.Attributes["paths"]
+			if !ok {
+				continue
+			}
+			value, diags := attr.Expr.Value(ctx)
+			if diags.HasErrors() {
+				return nil, fmt.Errorf("failed to evaluate dependencies paths in %s: %w", file, diags)
+			}
+			if !value.IsWhollyKnown() || value.IsNull() || !(value.Type().IsListType() || value.Type().IsTupleType()) {
+				return nil, fmt.Errorf("dependencies paths in %s must be a list of strings", file)
+			}
+			config.dependencies = []string{}
+			for it := value.ElementIterator(); it.Next(); {
+				_, element := it.Element()
+				if element.IsNull() || element.Type() != cty.String {
+					return nil, fmt.Errorf("dependencies paths in %s must be a list of strings", file)
+				}
+				config.dependencies = append(config.dependencies, e.dependencyDir(element.AsString()))
+			}
+		}
+	}
+	return config, nil
+}
+
+// dependencyDir resolves a dependency path relative to the unit, paths to a terragrunt.hcl mean its directory
+func (e *unitEvaluator) dependencyDir(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(e.unitDir, path)
+	}
+	if filepath.Ext(path) == ".hcl" {
+		path = filepath.Dir(path)
+	}
+	return filepath.Clean(path)
+}
+
+func evalString(expr hclsyntax.Expression, ctx *hcl.EvalContext) (string, error) {
+	value, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	if value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+		return "", fmt.Errorf("expected a string")
+	}
+	return value.AsString(), nil
+}
+
+// functions implements the terragrunt functions that locate files, which are all a dependency path can need
+func (e *unitEvaluator) functions() map[string]function.Function {
+	dirFunction := func(dir func() (string, error)) function.Function {
+		return function.New(&function.Spec{
+			Type: function.StaticReturnType(cty.String),
+			Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+				path, err := dir()
+				if err != nil {
+					return cty.NilVal, err
+				}
+				return cty.StringVal(filepath.ToSlash(path)), nil
+			},
+		})
+	}
+
+	functions := map[string]function.Function{
+		"find_in_parent_folders": function.New(&function.Spec{
+			VarParam: &function.Parameter{Name: "args", Type: cty.String},
+			Type:     function.StaticReturnType(cty.String),
+			Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+				if len(args) > 2 {
+					return cty.NilVal, fmt.Errorf("find_in_parent_folders takes at most 2 arguments")
+				}
+				name := "terragrunt.hcl"
+				if len(args) > 0 {
+					name = args[0].AsString()
+				}
+				for dir := filepath.Dir(e.unitDir); ; dir = filepath.Dir(dir) {
+					if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+						return cty.StringVal(filepath.ToSlash(filepath.Join(dir, name))), nil
+					}
+					if dir == filepath.Dir(dir) {
+						break
+					}
+				}
+				if len(args) == 2 {
+					return args[1], nil
+				}
+				return cty.NilVal, fmt.Errorf("could not find %s in any parent folder of %s", name, e.unitDir)
+			},
+		}),
+		"get_terragrunt_dir": dirFunction(func() (string, error) {
+			return e.unitDir, nil
+		}),
+		"get_original_terragrunt_dir": dirFunction(func() (string, error) {
+			return e.unitDir, nil
+		}),
+		"get_parent_terragrunt_dir": dirFunction(func() (string, error) {
+			return e.configDir, nil
+		}),
+		"path_relative_to_include": dirFunction(func() (string, error) {
+			return filepath.Rel(e.configDir, e.unitDir)
+		}),
+		"get_repo_root": dirFunction(func() (string, error) {
+			for dir := e.unitDir; ; dir = filepath.Dir(dir) {
+				if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+					return dir, nil
+				}
+				if dir == filepath.Dir(dir) {
+					return "", fmt.Errorf("%s is not in a git repository", e.unitDir)
+				}
+			}
+		}),
+	}
+	if e.decrypt {
+		for name, decryptFunction := range e.decryptFunctions() {
+			functions[name] = decryptFunction
+		}
+	}
+	return functions
+}
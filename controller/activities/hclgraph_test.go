@@ -0,0 +1,238 @@
+package activities
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+)
+
+func TestHCLGraph(t *testing.T) {
+	repoPath := writeRepo(t, map[string]string{
+		".git/HEAD": "ref: refs/heads/master\n",
+		"infra/production/root.hcl": `
+locals {
+  secrets = yamldecode(sops_decrypt_file(find_in_parent_folders("secrets.yaml")))
+}
+
+dependency "tfstate" {
+  config_path = "${get_repo_root()}/infra/production/tfstate"
+}
+`,
+		"infra/production/secrets.yaml": ``,
+		"infra/production/tfstate/terragrunt.hcl": `
+include "root" {
+  path           = find_in_parent_folders("root.hcl")
+  merge_strategy = "no_merge"
+}
+`,
+		"infra/production/network/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+`,
+		"infra/production/cluster/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+
+locals {
+  network_dir = "${local.parent}/network"
+  parent      = ".."
+}
+
+dependency "network" {
+  config_path = local.network_dir
+}
+`,
+		"infra/production/apps/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+
+dependency "cluster" {
+  config_path = "${get_terragrunt_dir()}/../cluster/terragrunt.hcl"
+}
+
+dependency "network" {
+  config_path = "../network"
+  enabled     = false
+}
+
+dependencies {
+  paths = ["../network", "../cluster"]
+}
+`,
+		"infra/production/apps/.terragrunt-cache/abc/terragrunt.hcl": `
+dependency "ignored" {
+  config_path = "../../../missing"
+}
+`,
+	})
+
+	graph, err := HCLGraph(context.Background(), filepath.Join(repoPath, "infra/production"))
+	require.NoError(t, err)
+
+	// terragrunt dag graph output for the same stack
+	expected, err := NewGraphFromDot(`digraph {
+	"apps" ;
+	"apps" -> "cluster";
+	"apps" -> "network";
+	"apps" -> "tfstate";
+	"cluster" ;
+	"cluster" -> "network";
+	"cluster" -> "tfstate";
+	"network" ;
+	"network" -> "tfstate";
+	"tfstate" ;
+}
+`)
+	require.NoError(t, err)
+	assert.Equal(t, expected, graph)
+}
+
+func TestHCLGraph_Repository(t *testing.T) {
+	graph, err := HCLGraph(context.Background(), "../../infra/production")
+	require.NoError(t, err)
+
+	expected, err := NewGraphFromDot(`digraph {
+	"metal/vn-south-1/bootstrap" ;
+	"metal/vn-south-1/bootstrap" -> "metal/vn-south-1/cluster";
+	"metal/vn-south-1/cluster" ;
+	"metal/vn-southwest-1/bootstrap" ;
+	"metal/vn-southwest-1/bootstrap" -> "metal/vn-southwest-1/cluster";
+	"metal/vn-southwest-1/cluster" ;
+	"oracle/legacy" ;
+	"tfstate" ;
+}
+`)
+	require.NoError(t, err)
+	assert.Equal(t, expected, graph)
+}
+
+func TestHCLGraph_MergeStrategies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy string
+		expected []string
+	}{
+		{
+			name:     "shallow merge replaces included dependencies",
+			strategy: "shallow",
+			expected: []string{"b", "c"},
+		},
+		{
+			name:     "deep merge concatenates dependencies",
+			strategy: "deep",
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name:     "no merge ignores the included configuration",
+			strategy: "no_merge",
+			expected: []string{"b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repoPath := writeRepo(t, map[string]string{
+				"infra/local/common.hcl": `
+dependency "c" {
+  config_path = "../c"
+}
+
+dependencies {
+  paths = ["../a"]
+}
+`,
+				"infra/local/a/terragrunt.hcl": ``,
+				"infra/local/b/terragrunt.hcl": ``,
+				"infra/local/c/terragrunt.hcl": ``,
+				"infra/local/unit/terragrunt.hcl": `
+include "common" {
+  path           = find_in_parent_folders("common.hcl")
+  merge_strategy = "` + tc.strategy + `"
+}
+
+dependencies {
+  paths = ["../b"]
+}
+`,
+			})
+
+			graph, err := HCLGraph(context.Background(), filepath.Join(repoPath, "infra/local"))
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, graph.Dependencies("unit"))
+		})
+	}
+}
+
+func TestHCLGraph_Errors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{
+			name: "dependency on a directory without terragrunt.hcl",
+			files: map[string]string{
+				"infra/local/app/terragrunt.hcl": `
+dependency "vpc" {
+  config_path = "../vpc"
+}
+`,
+			},
+			expected: "is not a terragrunt unit",
+		},
+		{
+			name: "config_path needing outputs",
+			files: map[string]string{
+				"infra/local/app/terragrunt.hcl": `
+dependency "vpc" {
+  config_path = dependency.other.outputs.path
+}
+`,
+			},
+			expected: `failed to evaluate config_path of dependency "vpc"`,
+		},
+		{
+			name: "invalid syntax",
+			files: map[string]string{
+				"infra/local/app/terragrunt.hcl": `dependency "vpc" {`,
+			},
+			expected: "failed to parse",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repoPath := writeRepo(t, tc.files)
+
+			graph, err := HCLGraph(context.Background(), filepath.Join(repoPath, "infra/local"))
+
+			assert.Nil(t, graph)
+			assert.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
+
+func TestHCLGraph_Cycle(t *testing.T) {
+	repoPath := writeRepo(t, map[string]string{
+		"infra/local/a/terragrunt.hcl": `dependencies { paths = ["../b"] }`,
+		"infra/local/b/terragrunt.hcl": `dependencies { paths = ["../a"] }`,
+	})
+
+	_, err := HCLGraph(context.Background(), filepath.Join(repoPath, "infra/local"))
+
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, DependencyCycleError, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+	var cycleErr *CycleError
+	require.ErrorAs(t, err, &cycleErr)
+	assert.Equal(t, []string{"a", "b", "a"}, cycleErr.Path)
+}
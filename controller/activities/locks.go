@@ -0,0 +1,303 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+// StateLock is the lock info terraform prints when the state of a module is locked by another run
+type StateLock struct {
+	ID        string `json:"id"`
+	Path      string `json:"path,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	// Who is user@host of the process that took the lock
+	Who     string    `json:"who,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Host is the host the lock was taken on
+func (l *StateLock) Host() string {
+	_, host, _ := strings.Cut(l.Who, "@")
+	return host
+}
+
+// Age is how long the lock has been held at now, rounded to the second
+func (l *StateLock) Age(now time.Time) time.Duration {
+	if l.Created.IsZero() {
+		return 0
+	}
+	return now.Sub(l.Created).Round(time.Second)
+}
+
+// terraform prints the creation time with time.Time.String
+const stateLockTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// parseStateLock reads the Lock Info block of a state lock error, from plain or boxed diagnostics, nil if the
+// output has none
+func parseStateLock(output string) *StateLock {
+	var lock *StateLock
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "│"))
+		if line == "Lock Info:" {
+			lock = &StateLock{}
+			continue
+		}
+		if lock == nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			break
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "ID":
+			lock.ID = value
+		case "Path":
+			lock.Path = value
+		case "Operation":
+			lock.Operation = value
+		case "Who":
+			lock.Who = value
+		case "Created":
+			lock.Created, _ = time.Parse(stateLockTimeLayout, value)
+		case "Version", "Info":
+		default:
+			return finishStateLock(lock)
+		}
+	}
+	return finishStateLock(lock)
+}
+
+func finishStateLock(lock *StateLock) *StateLock {
+	if lock == nil || lock.ID == "" {
+		return nil
+	}
+	return lock
+}
+
+// stateLockError reports a state lock with its ID, holder and age, the lock is attached as error details
+func stateLockError(message string, err error, lock *StateLock) error {
+	return temporal.NewApplicationErrorWithCause(
+		fmt.Sprintf("%s: state is locked by %s for %s (lock ID %s)", message, lock.Who, lock.Age(time.Now()), lock.ID),
+		TerraformStateLockError, err, *lock)
+}
+
+// stateLockOf returns the lock a failed command was refused by, nil for other errors
+func stateLockOf(err error) *StateLock {
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != TerraformStateLockError || !appErr.HasDetails() {
+		return nil
+	}
+	var lock StateLock
+	if appErr.Details(&lock) != nil {
+		return nil
+	}
+	return &lock
+}
+
+// LockHolder is the workflow run whose activity holds a state lock
+type LockHolder struct {
+	WorkflowID string `json:"workflowId"`
+	RunID      string `json:"runId"`
+	Activity   string `json:"activity"`
+}
+
+// Activities that run terraform and can take a state lock
+var lockingActivities = map[string]bool{
+	"TerragruntPlan":        true,
+	"TerragruntPlanDestroy": true,
+	"TerragruntSavePlan":    true,
+	"TerragruntApply":       true,
+	"TerragruntApplyPlan":   true,
+	"TerragruntDestroy":     true,
+	"TerragruntDrift":       true,
+	"TerragruntLockInfo":    true,
+}
+
+// Clocks of the workers and the Temporal server are not exactly in sync
+const lockClockSkew = time.Minute
+
+// matchLockHolder returns the pending activity that took a lock: terraform records the host of the worker it
+// ran on, so it is a started terragrunt activity on that host which started before the lock was created
+func matchLockHolder(lock *StateLock, pending []*workflowpb.PendingActivityInfo) *workflowpb.PendingActivityInfo {
+	for _, info := range pending {
+		if info.GetState() != enumspb.PENDING_ACTIVITY_STATE_STARTED || !lockingActivities[info.GetActivityType().GetName()] {
+			continue
+		}
+		// Worker identities default to pid@host@
+		parts := strings.Split(info.GetLastWorkerIdentity(), "@")
+		if len(parts) < 2 || parts[1] != lock.Host() {
+			continue
+		}
+		if started := info.GetLastStartedTime(); started != nil && !lock.Created.IsZero() && started.AsTime().After(lock.Created.Add(lockClockSkew)) {
+			continue
+		}
+		return info
+	}
+	return nil
+}
+
+// findLockHolder looks for the running workflow, other than self, that holds a lock
+func findLockHolder(ctx context.Context, temporalClient client.Client, lock *StateLock, self string) (*LockHolder, error) {
+	var token []byte
+	for {
+		response, err := temporalClient.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         "ExecutionStatus = 'Running'",
+			NextPageToken: token,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list running workflows: %w", err)
+		}
+
+		for _, execution := range response.GetExecutions() {
+			id, runID := execution.GetExecution().GetWorkflowId(), execution.GetExecution().GetRunId()
+			if id == self {
+				continue
+			}
+			description, err := temporalClient.DescribeWorkflowExecution(ctx, id, runID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to describe workflow %s: %w", id, err)
+			}
+			if info := matchLockHolder(lock, description.GetPendingActivities()); info != nil {
+				return &LockHolder{WorkflowID: id, RunID: runID, Activity: info.GetActivityType().GetName()}, nil
+			}
+		}
+
+		if token = response.GetNextPageToken(); len(token) == 0 {
+			return nil, nil
+		}
+	}
+}
+
+// StateLockHolder finds the running workflow holding a state lock, nil means no running workflow holds it
+func StateLockHolder(ctx context.Context, lock *StateLock) (*LockHolder, error) {
+	return findLockHolder(ctx, activity.GetClient(ctx), lock, activity.GetInfo(ctx).WorkflowExecution.ID)
+}
+
+// StateLockWait is the longest a terragrunt activity waits for a state lock, its start to close timeout has to
+// leave room for this on top of the command itself
+const StateLockWait = 20 * time.Minute
+
+// Waiting for a lock backs off exponentially up to stateLockMaxBackoff, for at most stateLockWait in total, and
+// heartbeats every stateLockHeartbeat so that a long backoff never trips the heartbeat timeout of the activity.
+// Variables so that tests do not have to wait.
+var (
+	stateLockBackoff    = 10 * time.Second
+	stateLockMaxBackoff = time.Minute
+	stateLockHeartbeat  = 20 * time.Second
+	stateLockWait       = StateLockWait
+	lockHolder          = StateLockHolder
+	lockHeartbeat       = safeHeartbeat
+)
+
+// waitForStateLock runs a terragrunt command again while the workflow holding its state lock is still running.
+// A lock without a running holder was left behind and is reported as non-retryable, it has to be released with
+// the ForceUnlock workflow.
+func waitForStateLock(ctx context.Context, module string, run func() error) error {
+	logger := activity.GetLogger(ctx)
+	backoff := stateLockBackoff
+	deadline := time.Now().Add(stateLockWait)
+
+	for {
+		err := run()
+		lock := stateLockOf(err)
+		if lock == nil {
+			return err
+		}
+
+		holder, holderErr := lockHolder(ctx, lock)
+		if holderErr != nil {
+			logger.Warn("Failed to find the state lock holder", "module", module, "lock", lock.ID, "error", holderErr)
+			return err
+		}
+		if holder == nil {
+			return temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("%s, no running workflow holds it, release it with the ForceUnlock workflow", err.Error()),
+				TerraformStateLockError, err, *lock)
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("%s, still held by workflow %s after %s", err.Error(), holder.WorkflowID, stateLockWait),
+				TerraformStateLockError, err, *lock)
+		}
+
+		logger.Info("Waiting for state lock", "module", module, "lock", lock.ID, "holder", holder.WorkflowID, "activity", holder.Activity, "backoff", backoff)
+		if err := sleepWithHeartbeat(ctx, backoff, fmt.Sprintf("Waiting for state lock %s held by %s", lock.ID, holder.WorkflowID)); err != nil {
+			return err
+		}
+		backoff = min(backoff*2, stateLockMaxBackoff)
+	}
+}
+
+// sleepWithHeartbeat waits for a duration, heartbeating every stateLockHeartbeat while it does
+func sleepWithHeartbeat(ctx context.Context, duration time.Duration, details string) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	heartbeatTicker := time.NewTicker(stateLockHeartbeat)
+	defer heartbeatTicker.Stop()
+
+	lockHeartbeat(ctx, details)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-heartbeatTicker.C:
+			lockHeartbeat(ctx, details)
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// TerragruntLockInfo returns the lock on the state of a module, nil if the state is not locked. It runs a plan
+// without refresh that gives up on the lock at once, the plan itself is thrown away.
+func TerragruntLockInfo(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*StateLock, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Reading terragrunt state lock", "module", modulePath, "stack", stack)
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	_, err = runTerragrunt(ctx, fullPath, "plan", "--backend-bootstrap", "-refresh=false", "-lock-timeout=0s", "-input=false")
+	if err == nil {
+		return nil, nil
+	}
+	if lock := parseStateLock(err.Error()); lock != nil {
+		return lock, nil
+	}
+	return nil, classifyTerraformError(fmt.Sprintf("terragrunt plan failed for module %s", modulePath), err, err.Error(), true)
+}
+
+// TerragruntForceUnlock releases a state lock by ID
+func TerragruntForceUnlock(ctx context.Context, repoUrl string, revision string, modulePath string, stack string, lockID string) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Running terragrunt force-unlock", "module", modulePath, "stack", stack, "lock", lockID)
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	if _, err := runTerragrunt(ctx, fullPath, "force-unlock", "-force", lockID); err != nil {
+		return fmt.Errorf("terragrunt force-unlock failed for module %s: %w", modulePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const lockOutput = `
+╷
+│ Error: Error acquiring the state lock
+│
+│ Error message: operation error S3: PutObject, https response error
+│ StatusCode: 412, PreconditionFailed
+│ Lock Info:
+│   ID:        6a7c5a2b-1d2e-4a8b-9f5a-6b8d0d1e2f3a
+│   Path:      tfstate/cluster/terraform.tfstate
+│   Operation: OperationTypeApply
+│   Who:       root@worker-7d9f
+│   Version:   1.9.0
+│   Created:   2025-01-01 12:00:00.123456789 +0000 UTC
+│   Info:
+│
+│
+│ OpenTofu acquires a state lock to protect the state from being written
+│ by multiple users at the same time.
+╵
+`
+
+func testStateLock() *StateLock {
+	return &StateLock{
+		ID:        "6a7c5a2b-1d2e-4a8b-9f5a-6b8d0d1e2f3a",
+		Path:      "tfstate/cluster/terraform.tfstate",
+		Operation: "OperationTypeApply",
+		Who:       "root@worker-7d9f",
+		Created:   time.Date(2025, 1, 1, 12, 0, 0, 123456789, time.UTC),
+	}
+}
+
+func TestParseStateLock(t *testing.T) {
+	lock := parseStateLock(lockOutput)
+
+	require.NotNil(t, lock)
+	assert.Equal(t, testStateLock().ID, lock.ID)
+	assert.Equal(t, testStateLock().Who, lock.Who)
+	assert.Equal(t, "tfstate/cluster/terraform.tfstate", lock.Path)
+	assert.True(t, testStateLock().Created.Equal(lock.Created))
+	assert.Equal(t, "worker-7d9f", lock.Host())
+	assert.Equal(t, 90*time.Second, lock.Age(lock.Created.Add(90*time.Second+400*time.Millisecond)))
+
+	// JSON diagnostics of apply -json are not boxed
+	lock = parseStateLock("Error message: ConditionalCheckFailedException\nLock Info:\n  ID:        1234\n  Who:       root@host\n")
+	require.NotNil(t, lock)
+	assert.Equal(t, "1234", lock.ID)
+
+	assert.Nil(t, parseStateLock("Error: Error acquiring the state lock"))
+}
+
+func TestClassifyTerraformError_StateLock(t *testing.T) {
+	err := classifyTerraformError("terragrunt apply failed for module cluster", errors.New("exit status 1"), lockOutput, false)
+
+	assert.ErrorContains(t, err, "state is locked by root@worker-7d9f for ")
+	assert.ErrorContains(t, err, "(lock ID 6a7c5a2b-1d2e-4a8b-9f5a-6b8d0d1e2f3a)")
+	lock := stateLockOf(err)
+	require.NotNil(t, lock)
+	assert.Equal(t, "6a7c5a2b-1d2e-4a8b-9f5a-6b8d0d1e2f3a", lock.ID)
+
+	assert.Nil(t, stateLockOf(errors.New("exit status 1")))
+}
+
+func TestMatchLockHolder(t *testing.T) {
+	lock := testStateLock()
+	pending := func(activityType string, identity string, started time.Time) *workflowpb.PendingActivityInfo {
+		return &workflowpb.PendingActivityInfo{
+			ActivityType:       &commonpb.ActivityType{Name: activityType},
+			State:              enumspb.PENDING_ACTIVITY_STATE_STARTED,
+			LastWorkerIdentity: identity,
+			LastStartedTime:    timestamppb.New(started),
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		pending  *workflowpb.PendingActivityInfo
+		expected bool
+	}{
+		{
+			name:     "apply on the same host before the lock",
+			pending:  pending("TerragruntApply", "42@worker-7d9f@", lock.Created.Add(-time.Minute)),
+			expected: true,
+		},
+		{
+			name:    "apply on another host",
+			pending: pending("TerragruntApply", "42@worker-1a2b@", lock.Created.Add(-time.Minute)),
+		},
+		{
+			name:    "started after the lock was taken",
+			pending: pending("TerragruntApply", "42@worker-7d9f@", lock.Created.Add(10*time.Minute)),
+		},
+		{
+			name:    "activity without terraform",
+			pending: pending("Clone", "42@worker-7d9f@", lock.Created.Add(-time.Minute)),
+		},
+		{
+			name: "scheduled but not started",
+			pending: &workflowpb.PendingActivityInfo{
+				ActivityType:       &commonpb.ActivityType{Name: "TerragruntApply"},
+				State:              enumspb.PENDING_ACTIVITY_STATE_SCHEDULED,
+				LastWorkerIdentity: "42@worker-7d9f@",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			holder := matchLockHolder(lock, []*workflowpb.PendingActivityInfo{tc.pending})
+
+			assert.Equal(t, tc.expected, holder != nil)
+		})
+	}
+}
+
+// restoreStateLockWait restores the state lock wait settings after a test changed them
+func restoreStateLockWait(t *testing.T) {
+	backoff, maxBackoff, heartbeat, wait, holderFunc, heartbeatFunc := stateLockBackoff, stateLockMaxBackoff, stateLockHeartbeat, stateLockWait, lockHolder, lockHeartbeat
+	t.Cleanup(func() {
+		stateLockBackoff, stateLockMaxBackoff, stateLockHeartbeat, stateLockWait, lockHolder, lockHeartbeat = backoff, maxBackoff, heartbeat, wait, holderFunc, heartbeatFunc
+	})
+}
+
+func TestWaitForStateLock(t *testing.T) {
+	restoreStateLockWait(t)
+	stateLockBackoff, stateLockMaxBackoff, stateLockWait = time.Millisecond, 2*time.Millisecond, time.Second
+
+	locked := stateLockError("terragrunt apply failed for module cluster", errors.New("exit status 1"), testStateLock())
+
+	testCases := []struct {
+		name     string
+		results  []error
+		holder   *LockHolder
+		expected string
+		runs     int
+	}{
+		{
+			name:    "waits while the holder runs",
+			results: []error{locked, locked, nil},
+			holder:  &LockHolder{WorkflowID: "infra-manual", Activity: "TerragruntApply"},
+			runs:    3,
+		},
+		{
+			name:     "gives up on a lock without running holder",
+			results:  []error{locked},
+			expected: "no running workflow holds it, release it with the ForceUnlock workflow",
+			runs:     1,
+		},
+		{
+			name:    "other errors are returned as is",
+			results: []error{errors.New("exit status 1")},
+			runs:    1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lockHolder = func(ctx context.Context, lock *StateLock) (*LockHolder, error) {
+				return tc.holder, nil
+			}
+			runs := 0
+			env := (&testsuite.WorkflowTestSuite{}).NewTestActivityEnvironment()
+
+			waitActivity := func(ctx context.Context) error {
+				return waitForStateLock(ctx, "cluster", func() error {
+					runs++
+					return tc.results[runs-1]
+				})
+			}
+			env.RegisterActivity(waitActivity)
+
+			_, err := env.ExecuteActivity(waitActivity)
+
+			assert.Equal(t, tc.runs, runs)
+			if tc.results[len(tc.results)-1] == nil {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			if tc.expected != "" {
+				assert.ErrorContains(t, err, tc.expected)
+				var appErr *temporal.ApplicationError
+				require.ErrorAs(t, err, &appErr)
+				assert.True(t, appErr.NonRetryable())
+			}
+		})
+	}
+}
+
+func TestWaitForStateLock_HeartbeatsWhileWaiting(t *testing.T) {
+	restoreStateLockWait(t)
+	// One backoff spans many heartbeat intervals, like a minute long backoff against a 20 second heartbeat
+	stateLockBackoff, stateLockMaxBackoff, stateLockHeartbeat, stateLockWait = 200*time.Millisecond, 200*time.Millisecond, 10*time.Millisecond, time.Second
+	lockHolder = func(ctx context.Context, lock *StateLock) (*LockHolder, error) {
+		return &LockHolder{WorkflowID: "infra-manual", Activity: "TerragruntApply"}, nil
+	}
+	locked := stateLockError("terragrunt apply failed for module cluster", errors.New("exit status 1"), testStateLock())
+
+	// The SDK throttles heartbeats to the server, count the ones the wait records instead
+	var heartbeats atomic.Int32
+	lockHeartbeat = func(ctx context.Context, details interface{}) {
+		heartbeats.Add(1)
+		safeHeartbeat(ctx, details)
+	}
+	env := (&testsuite.WorkflowTestSuite{}).NewTestActivityEnvironment()
+
+	// Heartbeats recorded before the command runs again were sent during the backoff
+	var duringWait int32
+	runs := 0
+	waitActivity := func(ctx context.Context) error {
+		return waitForStateLock(ctx, "cluster", func() error {
+			runs++
+			if runs == 1 {
+				return locked
+			}
+			duringWait = heartbeats.Load()
+			return nil
+		})
+	}
+	env.RegisterActivity(waitActivity)
+
+	_, err := env.ExecuteActivity(waitActivity)
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, runs)
+	assert.Greater(t, duringWait, int32(2))
+}
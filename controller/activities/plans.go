@@ -0,0 +1,408 @@
+package activities
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+// PlanArtifact is a saved binary plan of a module together with what it was planned against, applying it is
+// refused once the revision or the state moved on
+type PlanArtifact struct {
+	Module string `json:"module"`
+	// Digest is the sha256 checksum of the plan file, which is also its address in the plan store
+	Digest string `json:"digest"`
+	// Revision is the commit the plan was made from
+	Revision string `json:"revision"`
+	// Lineage and Serial identify the state the plan was made against
+	Lineage string       `json:"lineage,omitempty"`
+	Serial  int64        `json:"serial"`
+	Summary *PlanSummary `json:"summary"`
+}
+
+// PlanSet lists the saved plans of a plan run, it is saved in the plan store too so that an apply run only
+// needs its digest
+type PlanSet struct {
+	Stack string                   `json:"stack"`
+	Plans map[string]*PlanArtifact `json:"plans"`
+}
+
+// planStore keeps blobs by the sha256 of their content, digests look like sha256:<hex>
+type planStore interface {
+	put(ctx context.Context, data []byte) (string, error)
+	get(ctx context.Context, digest string) ([]byte, error)
+}
+
+// openPlanStore opens a plan store location: oci://<registry>/<repository> stores plans in a registry over TLS,
+// oci+http://<registry>/<repository> over plain HTTP for a local registry, anything else is a local directory.
+// Plans hold secrets of the providers, so plain HTTP is never used unless the location asks for it.
+func openPlanStore(location string) (planStore, error) {
+	for scheme, plainHTTP := range map[string]bool{"oci://": false, "oci+http://": true} {
+		if repository, ok := strings.CutPrefix(location, scheme); ok {
+			if repository == "" {
+				return nil, fmt.Errorf("plan store %q has no repository", location)
+			}
+			return &ociPlanStore{repository: repository, plainHTTP: plainHTTP}, nil
+		}
+	}
+
+	dir := strings.TrimPrefix(location, "file://")
+	if dir == "" {
+		return nil, fmt.Errorf("no plan store configured")
+	}
+	return &dirPlanStore{dir: dir}, nil
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// digestHex validates a digest and returns its hex part, which is safe to use in paths and tags
+func digestHex(digest string) (string, error) {
+	hexDigest, ok := strings.CutPrefix(digest, "sha256:")
+	if decoded, err := hex.DecodeString(hexDigest); !ok || err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return hexDigest, nil
+}
+
+// verifyDigest makes sure a blob read back from a store is the one that was saved
+func verifyDigest(digest string, data []byte) error {
+	if actual := digestOf(data); actual != digest {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("checksum mismatch for %s: got %s", digest, actual), PlanStaleError, nil)
+	}
+	return nil
+}
+
+// dirPlanStore stores blobs in a local directory as sha256/<hex>
+type dirPlanStore struct {
+	dir string
+}
+
+func (s *dirPlanStore) put(ctx context.Context, data []byte) (string, error) {
+	digest := digestOf(data)
+	hexDigest, _ := digestHex(digest)
+	path := filepath.Join(s.dir, "sha256", hexDigest)
+	if _, err := os.Stat(path); err == nil {
+		return digest, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create plan store: %w", err)
+	}
+	// Write then rename so that a blob is either complete or missing
+	tmp, err := os.CreateTemp(filepath.Dir(path), hexDigest+".*")
+	if err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", digest, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to save %s: %w", digest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", digest, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", digest, err)
+	}
+	return digest, nil
+}
+
+func (s *dirPlanStore) get(ctx context.Context, digest string) ([]byte, error) {
+	hexDigest, err := digestHex(digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, "sha256", hexDigest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", digest, err)
+	}
+	return data, verifyDigest(digest, data)
+}
+
+// ociPlanStore pushes every blob with oras as a single file artifact tagged with the hex of its digest
+type ociPlanStore struct {
+	repository string
+	plainHTTP  bool
+}
+
+// orasArgs builds the arguments of an oras command on the tag of a digest
+func (s *ociPlanStore) orasArgs(command string, hexDigest string, args ...string) []string {
+	orasArgs := []string{command}
+	if s.plainHTTP {
+		orasArgs = append(orasArgs, "--plain-http")
+	}
+	return append(append(orasArgs, s.repository+":"+hexDigest), args...)
+}
+
+const planBlobName = "blob"
+
+func (s *ociPlanStore) put(ctx context.Context, data []byte) (string, error) {
+	digest := digestOf(data)
+	hexDigest, _ := digestHex(digest)
+
+	dir, err := os.MkdirTemp("", "plan-store-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, planBlobName), data, 0o644); err != nil {
+		return "", err
+	}
+
+	if err := runOras(ctx, dir, s.orasArgs("push", hexDigest, planBlobName)...); err != nil {
+		return "", fmt.Errorf("failed to push %s: %w", digest, err)
+	}
+	return digest, nil
+}
+
+func (s *ociPlanStore) get(ctx context.Context, digest string) ([]byte, error) {
+	hexDigest, err := digestHex(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "plan-store-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := runOras(ctx, dir, s.orasArgs("pull", hexDigest)...); err != nil {
+		return nil, fmt.Errorf("failed to pull %s: %w", digest, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, planBlobName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", digest, err)
+	}
+	return data, verifyDigest(digest, data)
+}
+
+func runOras(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "oras", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("oras %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// stateVersion identifies a state snapshot, the serial grows with every write and the lineage changes when the
+// state is recreated
+type stateVersion struct {
+	Lineage string `json:"lineage"`
+	Serial  int64  `json:"serial"`
+}
+
+// parseStateVersion reads the output of `terragrunt state pull`, which is empty before the first apply
+func parseStateVersion(module string, data []byte) (*stateVersion, error) {
+	var version stateVersion
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &version, nil
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("failed to parse state for module %s: %w", module, err)
+	}
+	return &version, nil
+}
+
+func pullStateVersion(ctx context.Context, modulePath string, fullPath string) (*stateVersion, error) {
+	output, err := runTerragrunt(ctx, fullPath, "state", "pull")
+	if err != nil {
+		return nil, classifyTerraformError(fmt.Sprintf("terragrunt state pull failed for module %s", modulePath), err, err.Error(), true)
+	}
+	return parseStateVersion(modulePath, output)
+}
+
+func headRevision(ctx context.Context, repoPath string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = repoPath
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve revision of %s: %w", repoPath, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// checkPlanArtifact refuses to apply a plan made from another revision or against another state
+func checkPlanArtifact(artifact *PlanArtifact, revision string, state *stateVersion) error {
+	if artifact.Revision != revision {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("plan for module %s was made at revision %s, the revision is now %s", artifact.Module, artifact.Revision, revision),
+			PlanStaleError, nil)
+	}
+	if artifact.Lineage != state.Lineage || artifact.Serial != state.Serial {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("plan for module %s was made against state %s serial %d, the state is now %s serial %d",
+				artifact.Module, artifact.Lineage, artifact.Serial, state.Lineage, state.Serial),
+			PlanStaleError, nil)
+	}
+	return nil
+}
+
+// TerragruntSavePlan plans a module like TerragruntPlan and saves the binary plan file in the plan store
+func TerragruntSavePlan(ctx context.Context, repoUrl string, revision string, modulePath string, stack string, store string) (*PlanArtifact, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Running terragrunt plan", "module", modulePath, "stack", stack, "store", store)
+
+	plans, err := openPlanStore(store)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInput", err)
+	}
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+	commit, err := headRevision(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	dir, err := os.MkdirTemp("", "plan-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	planFile := filepath.Join(dir, "tfplan")
+
+	if err := waitForStateLock(ctx, modulePath, func() error {
+		if _, err := runTerragrunt(ctx, fullPath, "plan", "--backend-bootstrap", "-out="+planFile); err != nil {
+			return classifyTerraformError(fmt.Sprintf("terragrunt plan failed for module %s", modulePath), err, err.Error(), true)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// The plan bootstraps the backend, so the state can only be read afterwards. A write in between is still
+	// caught on apply, tofu refuses plans made against an older state.
+	state, err := pullStateVersion(ctx, modulePath, fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := runTerragrunt(ctx, fullPath, "show", "-json", planFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to show plan for module %s: %w", modulePath, err)
+	}
+	summary, err := parsePlan(modulePath, output)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(planFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan for module %s: %w", modulePath, err)
+	}
+	digest, err := plans.put(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Terragrunt plan saved", "module", modulePath, "digest", digest, "revision", commit, "serial", state.Serial)
+	return &PlanArtifact{
+		Module:   modulePath,
+		Digest:   digest,
+		Revision: commit,
+		Lineage:  state.Lineage,
+		Serial:   state.Serial,
+		Summary:  summary,
+	}, nil
+}
+
+// TerragruntApplyPlan applies a saved plan as is, after checking that revision still resolves to the commit
+// it was made from and that the state was not written since
+func TerragruntApplyPlan(ctx context.Context, repoUrl string, revision string, stack string, store string, artifact *PlanArtifact) (*ApplyReport, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Running terragrunt apply of a saved plan", "module", artifact.Module, "stack", stack, "digest", artifact.Digest)
+
+	plans, err := openPlanStore(store)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInput", err)
+	}
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+	commit, err := headRevision(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, artifact.Module)
+
+	state, err := pullStateVersion(ctx, artifact.Module, fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlanArtifact(artifact, commit, state); err != nil {
+		return nil, err
+	}
+
+	data, err := plans.get(ctx, artifact.Digest)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "plan-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	planFile := filepath.Join(dir, "tfplan")
+	if err := os.WriteFile(planFile, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	return terragruntApply(ctx, artifact.Module, fullPath, planFile)
+}
+
+// SavePlanSet saves the list of plans of a plan run and returns its digest
+func SavePlanSet(ctx context.Context, store string, set *PlanSet) (string, error) {
+	plans, err := openPlanStore(store)
+	if err != nil {
+		return "", temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInput", err)
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode plan set: %w", err)
+	}
+	return plans.put(ctx, data)
+}
+
+// LoadPlanSet reads a plan set saved by SavePlanSet
+func LoadPlanSet(ctx context.Context, store string, digest string) (*PlanSet, error) {
+	plans, err := openPlanStore(store)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInput", err)
+	}
+
+	data, err := plans.get(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	var set PlanSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode plan set %s: %w", digest, err)
+	}
+	return &set, nil
+}
@@ -0,0 +1,181 @@
+package activities
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+)
+
+func TestOpenPlanStore(t *testing.T) {
+	store, err := openPlanStore("oci://registry.local/plans")
+	require.NoError(t, err)
+	assert.Equal(t, &ociPlanStore{repository: "registry.local/plans"}, store)
+
+	store, err = openPlanStore("oci+http://registry.local/plans")
+	require.NoError(t, err)
+	assert.Equal(t, &ociPlanStore{repository: "registry.local/plans", plainHTTP: true}, store)
+
+	store, err = openPlanStore("file:///var/lib/plans")
+	require.NoError(t, err)
+	assert.Equal(t, &dirPlanStore{dir: "/var/lib/plans"}, store)
+
+	_, err = openPlanStore("oci://")
+	assert.EqualError(t, err, `plan store "oci://" has no repository`)
+
+	_, err = openPlanStore("oci+http://")
+	assert.EqualError(t, err, `plan store "oci+http://" has no repository`)
+
+	_, err = openPlanStore("")
+	assert.EqualError(t, err, "no plan store configured")
+}
+
+func TestOCIPlanStoreArgs(t *testing.T) {
+	// Plans hold provider secrets, they only leave over plain HTTP when the location asks for it
+	tls := &ociPlanStore{repository: "registry.example.com/plans"}
+	assert.Equal(t, []string{"push", "registry.example.com/plans:abc", "blob"}, tls.orasArgs("push", "abc", "blob"))
+
+	plain := &ociPlanStore{repository: "registry.local/plans", plainHTTP: true}
+	assert.Equal(t, []string{"pull", "--plain-http", "registry.local/plans:abc"}, plain.orasArgs("pull", "abc"))
+}
+
+func TestDigestHex(t *testing.T) {
+	digest := digestOf([]byte("plan"))
+
+	hexDigest, err := digestHex(digest)
+	require.NoError(t, err)
+	assert.Equal(t, digest, "sha256:"+hexDigest)
+
+	for _, invalid := range []string{"", "sha256:", "sha256:abc", "md5:" + hexDigest, "sha256:../../etc/passwd"} {
+		_, err := digestHex(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDirPlanStore(t *testing.T) {
+	store := &dirPlanStore{dir: t.TempDir()}
+
+	digest, err := store.put(context.Background(), []byte("plan"))
+	require.NoError(t, err)
+	assert.Equal(t, digestOf([]byte("plan")), digest)
+
+	// Saving the same content again is a no-op
+	again, err := store.put(context.Background(), []byte("plan"))
+	require.NoError(t, err)
+	assert.Equal(t, digest, again)
+
+	data, err := store.get(context.Background(), digest)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plan"), data)
+
+	_, err = store.get(context.Background(), digestOf([]byte("missing")))
+	assert.ErrorContains(t, err, "failed to read")
+}
+
+func TestDirPlanStore_Tampered(t *testing.T) {
+	store := &dirPlanStore{dir: t.TempDir()}
+	digest, err := store.put(context.Background(), []byte("plan"))
+	require.NoError(t, err)
+
+	hexDigest, _ := digestHex(digest)
+	require.NoError(t, os.WriteFile(filepath.Join(store.dir, "sha256", hexDigest), []byte("tampered"), 0o644))
+
+	_, err = store.get(context.Background(), digest)
+
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, PlanStaleError, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+}
+
+func TestParseStateVersion(t *testing.T) {
+	version, err := parseStateVersion("vpc", []byte(`{"version": 4, "lineage": "a1b2", "serial": 7, "resources": []}`))
+	require.NoError(t, err)
+	assert.Equal(t, &stateVersion{Lineage: "a1b2", Serial: 7}, version)
+
+	// No state before the first apply
+	version, err = parseStateVersion("vpc", []byte("\n"))
+	require.NoError(t, err)
+	assert.Equal(t, &stateVersion{}, version)
+
+	_, err = parseStateVersion("vpc", []byte("not json"))
+	assert.ErrorContains(t, err, "failed to parse state for module vpc")
+}
+
+func TestCheckPlanArtifact(t *testing.T) {
+	artifact := &PlanArtifact{Module: "vpc", Revision: "abc123", Lineage: "a1b2", Serial: 7}
+
+	testCases := []struct {
+		name     string
+		revision string
+		state    *stateVersion
+		expected string
+	}{
+		{
+			name:     "unchanged",
+			revision: "abc123",
+			state:    &stateVersion{Lineage: "a1b2", Serial: 7},
+		},
+		{
+			name:     "new revision",
+			revision: "def456",
+			state:    &stateVersion{Lineage: "a1b2", Serial: 7},
+			expected: "plan for module vpc was made at revision abc123, the revision is now def456",
+		},
+		{
+			name:     "state written since the plan",
+			revision: "abc123",
+			state:    &stateVersion{Lineage: "a1b2", Serial: 8},
+			expected: "plan for module vpc was made against state a1b2 serial 7, the state is now a1b2 serial 8",
+		},
+		{
+			name:     "state recreated",
+			revision: "abc123",
+			state:    &stateVersion{Lineage: "c3d4", Serial: 7},
+			expected: "plan for module vpc was made against state a1b2 serial 7, the state is now c3d4 serial 7",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkPlanArtifact(artifact, tc.revision, tc.state)
+
+			if tc.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var appErr *temporal.ApplicationError
+			require.ErrorAs(t, err, &appErr)
+			assert.Equal(t, PlanStaleError, appErr.Type())
+			assert.Equal(t, tc.expected, appErr.Message())
+		})
+	}
+}
+
+func TestPlanSetRoundTrip(t *testing.T) {
+	store := t.TempDir()
+	set := &PlanSet{
+		Stack: "production",
+		Plans: map[string]*PlanArtifact{
+			"vpc": {
+				Module:   "vpc",
+				Digest:   digestOf([]byte("plan")),
+				Revision: "abc123",
+				Lineage:  "a1b2",
+				Serial:   7,
+				Summary:  &PlanSummary{Add: 1},
+			},
+		},
+	}
+
+	digest, err := SavePlanSet(context.Background(), store, set)
+	require.NoError(t, err)
+
+	loaded, err := LoadPlanSet(context.Background(), "file://"+store, digest)
+	require.NoError(t, err)
+	assert.Equal(t, set, loaded)
+}
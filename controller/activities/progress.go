@@ -0,0 +1,208 @@
+package activities
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Resource statuses tracked from the OpenTofu machine-readable UI
+const (
+	ResourcePlanned   = "planned"
+	ResourceStarted   = "started"
+	ResourceCompleted = "completed"
+	ResourceErrored   = "errored"
+)
+
+type ResourceProgress struct {
+	Address        string  `json:"address"`
+	Action         string  `json:"action"`
+	Status         string  `json:"status"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+}
+
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+	Address  string `json:"address,omitempty"`
+}
+
+// ApplyProgress is sent as heartbeat details while an apply is running
+type ApplyProgress struct {
+	Module    string `json:"module"`
+	Planned   int    `json:"planned"`
+	Started   int    `json:"started"`
+	Completed int    `json:"completed"`
+	Errored   int    `json:"errored"`
+	Message   string `json:"message"`
+}
+
+// ApplyReport is the result of a terragrunt apply for a single module
+type ApplyReport struct {
+	Module      string             `json:"module"`
+	Add         int                `json:"add"`
+	Change      int                `json:"change"`
+	Destroy     int                `json:"destroy"`
+	Resources   []ResourceProgress `json:"resources,omitempty"`
+	Diagnostics []Diagnostic       `json:"diagnostics,omitempty"`
+	// Outputs are the module outputs after the apply, keyed by output name
+	Outputs map[string]ModuleOutput `json:"outputs,omitempty"`
+}
+
+// uiEvent is a single line of the OpenTofu `-json` UI event stream
+type uiEvent struct {
+	Type    string `json:"type"`
+	Message string `json:"@message"`
+	Hook    struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action         string  `json:"action"`
+		ElapsedSeconds float64 `json:"elapsed_seconds"`
+	} `json:"hook"`
+	Change struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"change"`
+	Changes struct {
+		Add    int `json:"add"`
+		Change int `json:"change"`
+		Remove int `json:"remove"`
+	} `json:"changes"`
+	Diagnostic Diagnostic `json:"diagnostic"`
+}
+
+// applyTracker folds the UI event stream of an apply into per-resource progress
+type applyTracker struct {
+	module      string
+	order       []string
+	resources   map[string]*ResourceProgress
+	summary     uiEvent
+	diagnostics []Diagnostic
+}
+
+func newApplyTracker(module string) *applyTracker {
+	return &applyTracker{
+		module:    module,
+		resources: make(map[string]*ResourceProgress),
+	}
+}
+
+func (t *applyTracker) resource(address string, action string) *ResourceProgress {
+	resource, ok := t.resources[address]
+	if !ok {
+		resource = &ResourceProgress{Address: address, Action: action}
+		t.resources[address] = resource
+		t.order = append(t.order, address)
+	}
+	if action != "" {
+		resource.Action = action
+	}
+	return resource
+}
+
+// handle consumes one output line and reports whether it was a UI event.
+// Terragrunt may prefix forwarded lines, so parsing starts at the first brace.
+func (t *applyTracker) handle(line string) bool {
+	start := strings.Index(line, "{")
+	if start < 0 {
+		return false
+	}
+
+	var event uiEvent
+	if err := json.Unmarshal([]byte(line[start:]), &event); err != nil || event.Type == "" {
+		return false
+	}
+
+	switch event.Type {
+	case "planned_change":
+		resource := t.resource(event.Change.Resource.Addr, event.Change.Action)
+		resource.Status = ResourcePlanned
+	case "apply_start":
+		resource := t.resource(event.Hook.Resource.Addr, event.Hook.Action)
+		resource.Status = ResourceStarted
+	case "apply_progress":
+		resource := t.resource(event.Hook.Resource.Addr, event.Hook.Action)
+		resource.ElapsedSeconds = event.Hook.ElapsedSeconds
+	case "apply_complete":
+		resource := t.resource(event.Hook.Resource.Addr, event.Hook.Action)
+		resource.Status = ResourceCompleted
+		resource.ElapsedSeconds = event.Hook.ElapsedSeconds
+	case "apply_errored":
+		resource := t.resource(event.Hook.Resource.Addr, event.Hook.Action)
+		resource.Status = ResourceErrored
+		resource.ElapsedSeconds = event.Hook.ElapsedSeconds
+	case "change_summary":
+		t.summary = event
+	case "diagnostic":
+		t.diagnostics = append(t.diagnostics, event.Diagnostic)
+	}
+
+	return true
+}
+
+func (t *applyTracker) progress() ApplyProgress {
+	progress := ApplyProgress{Module: t.module, Planned: len(t.resources)}
+	for _, resource := range t.resources {
+		switch resource.Status {
+		case ResourceStarted:
+			progress.Started++
+		case ResourceCompleted:
+			progress.Completed++
+		case ResourceErrored:
+			progress.Errored++
+		}
+	}
+	progress.Message = fmt.Sprintf("%d/%d resources applied", progress.Completed, progress.Planned)
+	if progress.Errored > 0 {
+		progress.Message += fmt.Sprintf(", %d errored", progress.Errored)
+	}
+	return progress
+}
+
+func (t *applyTracker) report() *ApplyReport {
+	report := &ApplyReport{
+		Module:      t.module,
+		Add:         t.summary.Changes.Add,
+		Change:      t.summary.Changes.Change,
+		Destroy:     t.summary.Changes.Remove,
+		Diagnostics: t.diagnostics,
+	}
+	for _, address := range t.order {
+		report.Resources = append(report.Resources, *t.resources[address])
+	}
+	return report
+}
+
+// errorSummary joins the summaries of all error diagnostics
+func (t *applyTracker) errorSummary() string {
+	var summaries []string
+	for _, diagnostic := range t.diagnostics {
+		if diagnostic.Severity == "error" {
+			summaries = append(summaries, diagnostic.Summary)
+		}
+	}
+	return strings.Join(summaries, "; ")
+}
+
+// applying reports whether any resource change has started
+func (t *applyTracker) applying() bool {
+	for _, resource := range t.resources {
+		if resource.Status != ResourcePlanned {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnosticsText renders every diagnostic for error classification
+func (t *applyTracker) diagnosticsText() string {
+	var lines []string
+	for _, diagnostic := range t.diagnostics {
+		lines = append(lines, fmt.Sprintf("%s: %s: %s", diagnostic.Severity, diagnostic.Summary, diagnostic.Detail))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,65 @@
+package activities
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const applyEvents = `{"@level":"info","@message":"OpenTofu 1.9.0","type":"version","tofu":"1.9.0","ui":"1.2"}
+{"@level":"info","@message":"null_resource.a: Plan to create","type":"planned_change","change":{"resource":{"addr":"null_resource.a"},"action":"create"}}
+{"@level":"info","@message":"null_resource.b: Plan to update","type":"planned_change","change":{"resource":{"addr":"null_resource.b"},"action":"update"}}
+{"@level":"info","@message":"null_resource.c: Plan to delete","type":"planned_change","change":{"resource":{"addr":"null_resource.c"},"action":"delete"}}
+{"@level":"info","@message":"Plan: 1 to add, 1 to change, 1 to destroy.","type":"change_summary","changes":{"add":1,"change":1,"import":0,"remove":1,"operation":"plan"}}
+{"@level":"info","@message":"null_resource.a: Creating...","type":"apply_start","hook":{"resource":{"addr":"null_resource.a"},"action":"create"}}
+{"@level":"info","@message":"null_resource.a: Still creating... [10s elapsed]","type":"apply_progress","hook":{"resource":{"addr":"null_resource.a"},"action":"create","elapsed_seconds":10}}
+{"@level":"info","@message":"null_resource.a: Creation complete after 12s","type":"apply_complete","hook":{"resource":{"addr":"null_resource.a"},"action":"create","elapsed_seconds":12}}
+{"@level":"info","@message":"null_resource.b: Modifying...","type":"apply_start","hook":{"resource":{"addr":"null_resource.b"},"action":"update"}}
+12:00:00.000 STDOUT tofu: {"@level":"info","@message":"null_resource.c: Destroying...","type":"apply_start","hook":{"resource":{"addr":"null_resource.c"},"action":"delete"}}`
+
+func TestApplyTracker_Progress(t *testing.T) {
+	tracker := newApplyTracker("cluster")
+	for _, line := range strings.Split(applyEvents, "\n") {
+		assert.True(t, tracker.handle(line), line)
+	}
+
+	progress := tracker.progress()
+
+	assert.Equal(t, "cluster", progress.Module)
+	assert.Equal(t, 3, progress.Planned)
+	assert.Equal(t, 2, progress.Started)
+	assert.Equal(t, 1, progress.Completed)
+	assert.Equal(t, "1/3 resources applied", progress.Message)
+}
+
+func TestApplyTracker_Report(t *testing.T) {
+	tracker := newApplyTracker("cluster")
+	for _, line := range strings.Split(applyEvents, "\n") {
+		tracker.handle(line)
+	}
+	tracker.handle(`{"@level":"error","@message":"null_resource.b: error","type":"apply_errored","hook":{"resource":{"addr":"null_resource.b"},"action":"update","elapsed_seconds":3}}`)
+	tracker.handle(`{"@level":"error","@message":"Error: boom","type":"diagnostic","diagnostic":{"severity":"error","summary":"boom","detail":"it broke","address":"null_resource.b"}}`)
+
+	report := tracker.report()
+
+	assert.Equal(t, 1, report.Add)
+	assert.Equal(t, 1, report.Change)
+	assert.Equal(t, 1, report.Destroy)
+	assert.Equal(t, []ResourceProgress{
+		{Address: "null_resource.a", Action: "create", Status: ResourceCompleted, ElapsedSeconds: 12},
+		{Address: "null_resource.b", Action: "update", Status: ResourceErrored, ElapsedSeconds: 3},
+		{Address: "null_resource.c", Action: "delete", Status: ResourceStarted},
+	}, report.Resources)
+	assert.Equal(t, "boom", tracker.errorSummary())
+	assert.Equal(t, "1/3 resources applied, 1 errored", tracker.progress().Message)
+}
+
+func TestApplyTracker_IgnoresPlainOutput(t *testing.T) {
+	tracker := newApplyTracker("cluster")
+
+	assert.False(t, tracker.handle("Initializing the backend..."))
+	assert.False(t, tracker.handle(`{"not": "an event"}`))
+	assert.False(t, tracker.handle(""))
+	assert.Empty(t, tracker.report().Resources)
+}
@@ -0,0 +1,181 @@
+package activities
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type NodeMark string
+
+const (
+	// NodeChanged is a module with changed files
+	NodeChanged NodeMark = "changed"
+	// NodeDependent is a module kept by PruneGraph because it depends on a changed one
+	NodeDependent NodeMark = "dependent"
+	// NodeSkipped is a module left out by PruneGraph
+	NodeSkipped NodeMark = "skipped"
+)
+
+type GraphFormat string
+
+const (
+	GraphFormatDot     GraphFormat = "dot"
+	GraphFormatMermaid GraphFormat = "mermaid"
+	GraphFormatJSON    GraphFormat = "json"
+)
+
+// GraphMarks highlights modules of a graph when rendering it, unmarked modules are rendered plain
+type GraphMarks map[string]NodeMark
+
+// PruneMarks marks every module of graph by what PruneGraph did with it, nothing is marked if pruned is the full graph
+func PruneMarks(graph *Graph, pruned *Graph, changed []string) GraphMarks {
+	marks := make(GraphMarks)
+	if pruned == nil || pruned == graph {
+		return marks
+	}
+
+	for _, node := range graph.GetNodes() {
+		if pruned.Nodes[node] {
+			marks[node] = NodeDependent
+		} else {
+			marks[node] = NodeSkipped
+		}
+	}
+	for _, node := range changed {
+		if graph.Nodes[node] {
+			marks[node] = NodeChanged
+		}
+	}
+	return marks
+}
+
+// GraphExport is the stable JSON schema of a rendered graph, nodes and edges are sorted
+type GraphExport struct {
+	Version int               `json:"version"`
+	Nodes   []GraphExportNode `json:"nodes"`
+	Edges   []GraphExportEdge `json:"edges"`
+}
+
+type GraphExportNode struct {
+	ID   string   `json:"id"`
+	Mark NodeMark `json:"mark,omitempty"`
+}
+
+// GraphExportEdge means From depends on To, like the edges of terragrunt dag graph
+type GraphExportEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+const graphExportVersion = 1
+
+// Export converts a graph to the JSON schema
+func (g *Graph) Export(marks GraphMarks) *GraphExport {
+	export := &GraphExport{
+		Version: graphExportVersion,
+		Nodes:   []GraphExportNode{},
+		Edges:   []GraphExportEdge{},
+	}
+	for _, node := range g.GetNodes() {
+		export.Nodes = append(export.Nodes, GraphExportNode{ID: node, Mark: marks[node]})
+		for _, dependency := range g.Dependencies(node) {
+			export.Edges = append(export.Edges, GraphExportEdge{From: node, To: dependency})
+		}
+	}
+	return export
+}
+
+// dotStyles and mermaidStyles give marked nodes the same colors in both formats
+var (
+	dotStyles = map[NodeMark]string{
+		NodeChanged:   `style="filled", fillcolor="#fde68a", color="#b45309"`,
+		NodeDependent: `style="filled", fillcolor="#bfdbfe", color="#1d4ed8"`,
+		NodeSkipped:   `style="dashed", color="#9ca3af", fontcolor="#9ca3af"`,
+	}
+	mermaidStyles = map[NodeMark]string{
+		NodeChanged:   "fill:#fde68a,stroke:#b45309",
+		NodeDependent: "fill:#bfdbfe,stroke:#1d4ed8",
+		NodeSkipped:   "fill:#f3f4f6,stroke:#9ca3af,stroke-dasharray:4,color:#9ca3af",
+	}
+	markOrder = []NodeMark{NodeChanged, NodeDependent, NodeSkipped}
+)
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// RenderDot renders a graph in the format of terragrunt dag graph, marked modules get a class and colors
+func RenderDot(g *Graph, marks GraphMarks) string {
+	var b strings.Builder
+	b.WriteString("digraph {\n")
+	for _, node := range g.GetNodes() {
+		if mark, ok := marks[node]; ok {
+			fmt.Fprintf(&b, "\t%s [class=%s, %s];\n", dotQuote(node), dotQuote(string(mark)), dotStyles[mark])
+		} else {
+			fmt.Fprintf(&b, "\t%s ;\n", dotQuote(node))
+		}
+		for _, dependency := range g.Dependencies(node) {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", dotQuote(node), dotQuote(dependency))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// RenderMermaid renders a graph as a Mermaid flowchart, node ids are generated because module paths
+// are not valid Mermaid ids
+func RenderMermaid(g *Graph, marks GraphMarks) string {
+	nodes := g.GetNodes()
+	ids := make(map[string]string, len(nodes))
+	for i, node := range nodes {
+		ids[node] = fmt.Sprintf("n%d", i)
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for _, node := range nodes {
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", ids[node], strings.ReplaceAll(node, `"`, "#quot;"))
+	}
+	for _, node := range nodes {
+		for _, dependency := range g.Dependencies(node) {
+			fmt.Fprintf(&b, "    %s --> %s\n", ids[node], ids[dependency])
+		}
+	}
+	for _, mark := range markOrder {
+		var marked []string
+		for _, node := range nodes {
+			if marks[node] == mark {
+				marked = append(marked, ids[node])
+			}
+		}
+		if len(marked) > 0 {
+			fmt.Fprintf(&b, "    classDef %s %s\n", mark, mermaidStyles[mark])
+			fmt.Fprintf(&b, "    class %s %s\n", strings.Join(marked, ","), mark)
+		}
+	}
+	return b.String()
+}
+
+// RenderJSON renders a graph with the GraphExport schema
+func RenderJSON(g *Graph, marks GraphMarks) (string, error) {
+	data, err := json.MarshalIndent(g.Export(marks), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render graph: %w", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// RenderGraph renders a graph in any supported format
+func RenderGraph(g *Graph, marks GraphMarks, format GraphFormat) (string, error) {
+	switch format {
+	case GraphFormatDot:
+		return RenderDot(g, marks), nil
+	case GraphFormatMermaid:
+		return RenderMermaid(g, marks), nil
+	case GraphFormatJSON:
+		return RenderJSON(g, marks)
+	default:
+		return "", fmt.Errorf("unknown graph format %q, expected one of dot, mermaid or json", format)
+	}
+}
@@ -0,0 +1,117 @@
+package activities
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func renderGraph() (*Graph, GraphMarks) {
+	graph := NewGraph()
+	graph.AddEdge("bootstrap", "cluster")
+	graph.AddEdge("platform", "bootstrap")
+	graph.AddNode("dns")
+
+	pruned, _ := PruneGraph(context.Background(), graph, []string{"bootstrap"})
+	return graph, PruneMarks(graph, pruned, []string{"bootstrap"})
+}
+
+func TestPruneMarks(t *testing.T) {
+	_, marks := renderGraph()
+
+	assert.Equal(t, GraphMarks{
+		"bootstrap": NodeChanged,
+		"platform":  NodeDependent,
+		"cluster":   NodeSkipped,
+		"dns":       NodeSkipped,
+	}, marks)
+}
+
+func TestPruneMarks_FullGraph(t *testing.T) {
+	graph, _ := renderGraph()
+
+	assert.Empty(t, PruneMarks(graph, graph, nil))
+}
+
+func TestRenderDot(t *testing.T) {
+	graph, marks := renderGraph()
+
+	assert.Equal(t, `digraph {
+	"bootstrap" [class="changed", style="filled", fillcolor="#fde68a", color="#b45309"];
+	"bootstrap" -> "cluster";
+	"cluster" [class="skipped", style="dashed", color="#9ca3af", fontcolor="#9ca3af"];
+	"dns" [class="skipped", style="dashed", color="#9ca3af", fontcolor="#9ca3af"];
+	"platform" [class="dependent", style="filled", fillcolor="#bfdbfe", color="#1d4ed8"];
+	"platform" -> "bootstrap";
+}
+`, RenderDot(graph, marks))
+}
+
+func TestRenderDot_Unmarked(t *testing.T) {
+	graph := NewGraph()
+	graph.AddEdge(`metal/"quoted"`, "cluster")
+
+	assert.Equal(t, `digraph {
+	"cluster" ;
+	"metal/\"quoted\"" ;
+	"metal/\"quoted\"" -> "cluster";
+}
+`, RenderDot(graph, nil))
+}
+
+func TestRenderMermaid(t *testing.T) {
+	graph, marks := renderGraph()
+
+	assert.Equal(t, `flowchart LR
+    n0["bootstrap"]
+    n1["cluster"]
+    n2["dns"]
+    n3["platform"]
+    n0 --> n1
+    n3 --> n0
+    classDef changed fill:#fde68a,stroke:#b45309
+    class n0 changed
+    classDef dependent fill:#bfdbfe,stroke:#1d4ed8
+    class n3 dependent
+    classDef skipped fill:#f3f4f6,stroke:#9ca3af,stroke-dasharray:4,color:#9ca3af
+    class n1,n2 skipped
+`, RenderMermaid(graph, marks))
+}
+
+func TestRenderJSON(t *testing.T) {
+	graph, marks := renderGraph()
+
+	output, err := RenderJSON(graph, marks)
+	require.NoError(t, err)
+
+	var export GraphExport
+	require.NoError(t, json.Unmarshal([]byte(output), &export))
+	assert.Equal(t, GraphExport{
+		Version: 1,
+		Nodes: []GraphExportNode{
+			{ID: "bootstrap", Mark: NodeChanged},
+			{ID: "cluster", Mark: NodeSkipped},
+			{ID: "dns", Mark: NodeSkipped},
+			{ID: "platform", Mark: NodeDependent},
+		},
+		Edges: []GraphExportEdge{
+			{From: "bootstrap", To: "cluster"},
+			{From: "platform", To: "bootstrap"},
+		},
+	}, export)
+
+	empty, err := RenderJSON(NewGraph(), nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version": 1, "nodes": [], "edges": []}`, empty)
+}
+
+func TestRenderGraph_UnknownFormat(t *testing.T) {
+	graph, marks := renderGraph()
+
+	_, err := RenderGraph(graph, marks, "svg")
+
+	assert.ErrorContains(t, err, `unknown graph format "svg"`)
+}
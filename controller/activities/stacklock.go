@@ -0,0 +1,74 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+)
+
+const (
+	// StackLockWorkflow is the workflow type serialising the runs of a stack, one execution per stack
+	StackLockWorkflow = "StackLock"
+	// StackLockRequestSignal queues a StackLockRequest, it starts the StackLock workflow if needed
+	StackLockRequestSignal = "stack-lock-request"
+)
+
+// StackLockRequest asks the StackLock workflow of a stack for the right to apply it
+type StackLockRequest struct {
+	WorkflowID string
+	RunID      string
+	Revision   string
+	// OldRevision is the base a run prunes from, empty for a run of the whole stack
+	OldRevision string `json:",omitempty"`
+	// Supersedable runs replace queued supersedable runs and are replaced by later ones, runs of selected modules
+	// or saved plans are never replaced
+	Supersedable bool `json:",omitempty"`
+	// Requested is when the StackLock workflow received the request
+	Requested time.Time `json:",omitempty"`
+}
+
+// StackLockState is the input of the StackLock workflow, which carries the holder and queue over when it
+// continues as new
+type StackLockState struct {
+	Stack  string
+	Holder *StackLockRequest   `json:",omitempty"`
+	Queue  []*StackLockRequest `json:",omitempty"`
+}
+
+// StackLockWorkflowID is the workflow ID of the StackLock workflow of a stack
+func StackLockWorkflowID(stack string) string {
+	return "stack-lock-" + strings.ReplaceAll(stack, "/", "-")
+}
+
+// RequestStackLock queues a run for a stack, starting the StackLock workflow of the stack if it is not running
+func RequestStackLock(ctx context.Context, stack string, request StackLockRequest) error {
+	id := StackLockWorkflowID(stack)
+	_, err := activity.GetClient(ctx).SignalWithStartWorkflow(ctx, id, StackLockRequestSignal, request, client.StartWorkflowOptions{
+		ID:        id,
+		TaskQueue: activity.GetInfo(ctx).TaskQueue,
+	}, StackLockWorkflow, StackLockState{Stack: stack})
+	if err != nil {
+		return fmt.Errorf("failed to request lock of stack %s: %w", stack, err)
+	}
+	return nil
+}
+
+// WorkflowRunning reports whether a workflow run is still running
+func WorkflowRunning(ctx context.Context, workflowID string, runID string) (bool, error) {
+	description, err := activity.GetClient(ctx).DescribeWorkflowExecution(ctx, workflowID, runID)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to describe workflow %s: %w", workflowID, err)
+	}
+	return description.GetWorkflowExecutionInfo().GetStatus() == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING, nil
+}
@@ -0,0 +1,250 @@
+package activities
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"gopkg.in/yaml.v3"
+)
+
+// s3Backend is the S3 backend a unit keeps its state in, empty fields are left to the AWS defaults
+type s3Backend struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// unitBackend reads the S3 backend of a unit from its terragrunt configuration: a remote_state block, or a
+// generate "backend" block writing a terraform backend "s3" block, in the unit or in the files it includes.
+// Secrets of the configuration are decrypted with sops like terragrunt does.
+func unitBackend(unitDir string) (*s3Backend, error) {
+	unitDir, err := filepath.Abs(unitDir)
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(unitDir, "terragrunt.hcl")
+	body, err := parseHCLFile(file)
+	if err != nil {
+		return nil, err
+	}
+	unitEval := &unitEvaluator{unitDir: unitDir, configDir: unitDir, decrypt: true}
+	unitCtx := unitEval.context(body)
+
+	// The configuration of the unit wins over the included ones
+	if backend, err := configBackend(file, body, unitCtx); backend != nil || err != nil {
+		return backend, err
+	}
+	for _, block := range body.Blocks {
+		if block.Type != "include" {
+			continue
+		}
+		attr, ok := block.Body.Attributes["path"]
+		if !ok {
+			return nil, fmt.Errorf("include block in %s has no path", file)
+		}
+		includePath, err := evalString(attr.Expr, unitCtx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate include path in %s: %w", file, err)
+		}
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(unitDir, includePath)
+		}
+		includeBody, err := parseHCLFile(includePath)
+		if err != nil {
+			return nil, err
+		}
+		includeEval := &unitEvaluator{unitDir: unitDir, configDir: filepath.Dir(includePath), decrypt: true}
+		if backend, err := configBackend(includePath, includeBody, includeEval.context(includeBody)); backend != nil || err != nil {
+			return backend, err
+		}
+	}
+	return nil, fmt.Errorf("no s3 backend is configured for %s", unitDir)
+}
+
+// configBackend returns the S3 backend of one configuration file, nil if it configures none
+func configBackend(file string, body *hclsyntax.Body, ctx *hcl.EvalContext) (*s3Backend, error) {
+	for _, block := range body.Blocks {
+		switch {
+		case block.Type == "remote_state":
+			attr, ok := block.Body.Attributes["backend"]
+			if !ok {
+				continue
+			}
+			backend, err := evalString(attr.Expr, ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate remote_state backend in %s: %w", file, err)
+			}
+			if backend != "s3" {
+				return nil, fmt.Errorf("remote_state backend in %s is %s, not s3", file, backend)
+			}
+			attr, ok = block.Body.Attributes["config"]
+			if !ok {
+				return &s3Backend{}, nil
+			}
+			config, diags := attr.Expr.Value(ctx)
+			if diags.HasErrors() {
+				return nil, fmt.Errorf("failed to evaluate remote_state config in %s: %w", file, diags)
+			}
+			return backendFromConfig(config), nil
+		case block.Type == "generate" && len(block.Labels) == 1 && block.Labels[0] == "backend":
+			attr, ok := block.Body.Attributes["contents"]
+			if !ok {
+				continue
+			}
+			contents, err := evalString(attr.Expr, ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate generated backend in %s: %w", file, err)
+			}
+			backend, err := generatedBackend(file, contents)
+			if backend != nil || err != nil {
+				return backend, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// generatedBackend reads the terraform backend "s3" block of generated terraform code
+func generatedBackend(file string, contents string) (*s3Backend, error) {
+	parsed, diags := hclsyntax.ParseConfig([]byte(contents), file+":backend", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse generated backend in %s: %w", file, diags)
+	}
+	for _, terraform := range parsed.Body.(*hclsyntax.Body).Blocks {
+		if terraform.Type != "terraform" {
+			continue
+		}
+		for _, block := range terraform.Body.Blocks {
+			if block.Type != "backend" || len(block.Labels) != 1 || block.Labels[0] != "s3" {
+				continue
+			}
+			config := make(map[string]cty.Value)
+			for name, attr := range block.Body.Attributes {
+				value, diags := attr.Expr.Value(nil)
+				if diags.HasErrors() {
+					return nil, fmt.Errorf("failed to evaluate %s of the generated backend in %s: %w", name, file, diags)
+				}
+				config[name] = value
+			}
+			return backendFromConfig(cty.ObjectVal(config)), nil
+		}
+	}
+	return nil, nil
+}
+
+func backendFromConfig(config cty.Value) *s3Backend {
+	endpoint := ctyString(config, "endpoints", "s3")
+	if endpoint == "" {
+		// Deprecated before endpoints existed
+		endpoint = ctyString(config, "endpoint")
+	}
+	return &s3Backend{
+		Endpoint:  endpoint,
+		Region:    ctyString(config, "region"),
+		AccessKey: ctyString(config, "access_key"),
+		SecretKey: ctyString(config, "secret_key"),
+	}
+}
+
+// ctyString returns the string at a path of nested objects or maps, empty if there is none
+func ctyString(value cty.Value, path ...string) string {
+	for _, name := range path {
+		if value.IsNull() || !value.IsKnown() {
+			return ""
+		}
+		switch {
+		case value.Type().IsObjectType() && value.Type().HasAttribute(name):
+			value = value.GetAttr(name)
+		case value.Type().IsMapType() && value.HasIndex(cty.StringVal(name)).True():
+			value = value.Index(cty.StringVal(name))
+		default:
+			return ""
+		}
+	}
+	if value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+		return ""
+	}
+	return value.AsString()
+}
+
+// decryptFunctions implements the terragrunt functions reading secrets, only the backend of a unit needs them
+func (e *unitEvaluator) decryptFunctions() map[string]function.Function {
+	return map[string]function.Function{
+		"sops_decrypt_file": function.New(&function.Spec{
+			Params: []function.Parameter{{Name: "path", Type: cty.String}},
+			Type:   function.StaticReturnType(cty.String),
+			Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+				path := args[0].AsString()
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(e.unitDir, path)
+				}
+				cmd := exec.Command("sops", "--decrypt", path)
+				var stderr strings.Builder
+				cmd.Stderr = &stderr
+				output, err := cmd.Output()
+				if err != nil {
+					return cty.NilVal, fmt.Errorf("failed to decrypt %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+				}
+				return cty.StringVal(string(output)), nil
+			},
+		}),
+		"yamldecode": function.New(&function.Spec{
+			Params: []function.Parameter{{Name: "src", Type: cty.String}},
+			Type:   function.StaticReturnType(cty.DynamicPseudoType),
+			Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+				return yamlValue([]byte(args[0].AsString()))
+			},
+		}),
+	}
+}
+
+// yamlValue converts a YAML document to the cty value yamldecode returns, through JSON
+func yamlValue(data []byte) (cty.Value, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return cty.NilVal, err
+	}
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	valueType, err := ctyjson.ImpliedType(encoded)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	return ctyjson.Unmarshal(encoded, valueType)
+}
+
+// backupBackend is the S3 service backups of a unit are written to: the backend of the unit, with the
+// STATE_BACKUP_ENDPOINT, STATE_BACKUP_REGION, STATE_BACKUP_ACCESS_KEY_ID and STATE_BACKUP_SECRET_ACCESS_KEY
+// variables as explicit overrides. Credentials the backend does not configure come from AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, as for the backend itself.
+func backupBackend(backend *s3Backend, getenv func(string) string) s3Backend {
+	resolved := *backend
+	override := func(field *string, name string) {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+	override(&resolved.Endpoint, "STATE_BACKUP_ENDPOINT")
+	override(&resolved.Region, "STATE_BACKUP_REGION")
+	override(&resolved.AccessKey, "STATE_BACKUP_ACCESS_KEY_ID")
+	override(&resolved.SecretKey, "STATE_BACKUP_SECRET_ACCESS_KEY")
+
+	if resolved.AccessKey == "" && resolved.SecretKey == "" {
+		resolved.AccessKey, resolved.SecretKey = getenv("AWS_ACCESS_KEY_ID"), getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if resolved.Endpoint == "" {
+		resolved.Endpoint = "https://s3.amazonaws.com"
+	}
+	return resolved
+}
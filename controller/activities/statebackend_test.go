@@ -0,0 +1,107 @@
+package activities
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitBackend_RemoteState(t *testing.T) {
+	// The local stack of the repository configures MinIO in a remote_state block
+	backend, err := unitBackend("../../infra/local/cluster")
+	require.NoError(t, err)
+	assert.Equal(t, &s3Backend{Endpoint: "http://localhost:9000", Region: "eu-west-1"}, backend)
+}
+
+func TestUnitBackend_GeneratedBackend(t *testing.T) {
+	// A fake sops prints the secrets as they are after decryption
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "sops"), []byte(`#!/bin/sh
+cat <<EOF
+cloudflare_account_id: 0123456789abcdef
+cloudflare_tfstate_access_key: r2-key
+cloudflare_tfstate_secret_key: r2-secret
+EOF
+`), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	repoPath := writeRepo(t, map[string]string{
+		"infra/production/root.hcl": `
+locals {
+  secrets = yamldecode(sops_decrypt_file(find_in_parent_folders("secrets.yaml")))
+  env     = "production"
+}
+
+generate "backend" {
+  path      = "backend.tf"
+  if_exists = "overwrite_terragrunt"
+  contents  = <<EOF
+terraform {
+  backend "s3" {
+    bucket     = "tfstate-${local.env}"
+    key        = "${path_relative_to_include()}/tfstate.json"
+    region     = "auto"
+    access_key = "${local.secrets.cloudflare_tfstate_access_key}"
+    secret_key = "${local.secrets.cloudflare_tfstate_secret_key}"
+    endpoints  = { s3 = "https://${local.secrets.cloudflare_account_id}.r2.cloudflarestorage.com" }
+  }
+}
+EOF
+}
+`,
+		"infra/production/secrets.yaml": `cloudflare_account_id: ENC[AES256_GCM,data:...]`,
+		"infra/production/cluster/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+`,
+	})
+
+	backend, err := unitBackend(filepath.Join(repoPath, "infra/production/cluster"))
+	require.NoError(t, err)
+	assert.Equal(t, &s3Backend{
+		Endpoint:  "https://0123456789abcdef.r2.cloudflarestorage.com",
+		Region:    "auto",
+		AccessKey: "r2-key",
+		SecretKey: "r2-secret",
+	}, backend)
+}
+
+func TestUnitBackend_NotConfigured(t *testing.T) {
+	repoPath := writeRepo(t, map[string]string{
+		"infra/dev/cluster/terragrunt.hcl": `
+terraform {
+  source = "../../modules/cluster"
+}
+`,
+	})
+
+	_, err := unitBackend(filepath.Join(repoPath, "infra/dev/cluster"))
+	assert.ErrorContains(t, err, "no s3 backend is configured")
+}
+
+func TestBackupBackend(t *testing.T) {
+	env := map[string]string{
+		"AWS_ENDPOINT_URL_S3":   "http://localhost:9000",
+		"AWS_ACCESS_KEY_ID":     "minioadmin",
+		"AWS_SECRET_ACCESS_KEY": "minioadmin",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	// The backend of the unit wins over the environment of the worker
+	r2 := &s3Backend{Endpoint: "https://0123456789abcdef.r2.cloudflarestorage.com", Region: "auto", AccessKey: "r2-key", SecretKey: "r2-secret"}
+	assert.Equal(t, *r2, backupBackend(r2, getenv))
+
+	// Credentials the backend leaves out come from the environment, as for terraform
+	minio := &s3Backend{Endpoint: "http://localhost:9000", Region: "eu-west-1"}
+	assert.Equal(t, s3Backend{Endpoint: "http://localhost:9000", Region: "eu-west-1", AccessKey: "minioadmin", SecretKey: "minioadmin"}, backupBackend(minio, getenv))
+
+	// Explicit overrides
+	env["STATE_BACKUP_ENDPOINT"] = "https://backups.example.com"
+	env["STATE_BACKUP_ACCESS_KEY_ID"] = "backup-key"
+	env["STATE_BACKUP_SECRET_ACCESS_KEY"] = "backup-secret"
+	assert.Equal(t, s3Backend{Endpoint: "https://backups.example.com", Region: "auto", AccessKey: "backup-key", SecretKey: "backup-secret"}, backupBackend(r2, getenv))
+}
@@ -2,7 +2,10 @@ package activities
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
 	"path/filepath"
@@ -10,6 +13,7 @@ import (
 	"time"
 
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 )
 
 func TerragruntGraph(ctx context.Context, path string) (*Graph, error) {
@@ -20,86 +24,376 @@ func TerragruntGraph(ctx context.Context, path string) (*Graph, error) {
 		return nil, fmt.Errorf("failed to run terragrunt dag graph: %w", err)
 	}
 
-	return NewGraphFromDot(string(output))
+	graph, err := NewGraphFromDot(string(output))
+	if err != nil {
+		// The same output would fail to parse again
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), GraphSyntaxError, err)
+	}
+	if err := graph.DetectCycle(); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), DependencyCycleError, err)
+	}
+
+	return graph, nil
 }
 
 func TerragruntPrune(ctx context.Context, graph *Graph, changedFiles []string) (*Graph, error) {
 	return PruneGraph(ctx, graph, changedFiles)
 }
 
-func TerragruntApply(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) error {
+// PlanSummary describes what a terragrunt plan would do to a module.
+type PlanSummary struct {
+	Module    string           `json:"module"`
+	Add       int              `json:"add"`
+	Change    int              `json:"change"`
+	Destroy   int              `json:"destroy"`
+	Resources []ResourceChange `json:"resources,omitempty"`
+}
+
+type ResourceChange struct {
+	Address string   `json:"address"`
+	Actions []string `json:"actions"`
+}
+
+func (p *PlanSummary) HasChanges() bool {
+	return p.Add+p.Change+p.Destroy > 0
+}
+
+// parsePlan summarises the output of `tofu show -json` for a saved plan.
+func parsePlan(module string, data []byte) (*PlanSummary, error) {
+	var plan struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Change  struct {
+				Actions []string `json:"actions"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan for module %s: %w", module, err)
+	}
+
+	summary := &PlanSummary{Module: module}
+	for _, rc := range plan.ResourceChanges {
+		changed := false
+		for _, action := range rc.Change.Actions {
+			switch action {
+			case "create":
+				summary.Add++
+				changed = true
+			case "update":
+				summary.Change++
+				changed = true
+			case "delete":
+				summary.Destroy++
+				changed = true
+			}
+		}
+		if changed {
+			summary.Resources = append(summary.Resources, ResourceChange{
+				Address: rc.Address,
+				Actions: rc.Change.Actions,
+			})
+		}
+	}
+
+	return summary, nil
+}
+
+// ModuleOutput is a terraform output of a module, sensitive values are redacted so that they never end up in
+// workflow histories
+type ModuleOutput struct {
+	Value     any  `json:"value,omitempty"`
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+// parseOutputs reads the output of `terragrunt output -json`
+func parseOutputs(module string, data []byte) (map[string]ModuleOutput, error) {
+	var raw map[string]struct {
+		Sensitive bool `json:"sensitive"`
+		Value     any  `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse outputs for module %s: %w", module, err)
+	}
+
+	outputs := make(map[string]ModuleOutput, len(raw))
+	for name, output := range raw {
+		if output.Sensitive {
+			outputs[name] = ModuleOutput{Sensitive: true}
+		} else {
+			outputs[name] = ModuleOutput{Value: output.Value}
+		}
+	}
+	return outputs, nil
+}
+
+// runTerragrunt runs a terragrunt command to completion, sending heartbeats while it runs.
+func runTerragrunt(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "terragrunt", args...)
+	cmd.Dir = dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start terragrunt %s: %w", args[0], err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	heartbeatTicker := time.NewTicker(25 * time.Second)
+	defer heartbeatTicker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return stdout.Bytes(), fmt.Errorf("terragrunt %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+			}
+			return stdout.Bytes(), nil
+		case <-heartbeatTicker.C:
+			safeHeartbeat(ctx, fmt.Sprintf("Terragrunt %s in progress", args[0]))
+		}
+	}
+}
+
+func TerragruntPlan(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*PlanSummary, error) {
+	return terragruntPlan(ctx, repoUrl, revision, modulePath, stack, false)
+}
+
+// TerragruntPlanDestroy plans the destruction of every resource of a module
+func TerragruntPlanDestroy(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*PlanSummary, error) {
+	return terragruntPlan(ctx, repoUrl, revision, modulePath, stack, true)
+}
+
+func terragruntPlan(ctx context.Context, repoUrl string, revision string, modulePath string, stack string, destroy bool) (*PlanSummary, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Running terragrunt plan", "module", modulePath, "stack", stack, "destroy", destroy)
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	args := []string{"plan", "--backend-bootstrap", "-out=tfplan"}
+	if destroy {
+		args = append(args, "-destroy")
+	}
+	if err := waitForStateLock(ctx, modulePath, func() error {
+		if _, err := runTerragrunt(ctx, fullPath, args...); err != nil {
+			return classifyTerraformError(fmt.Sprintf("terragrunt plan failed for module %s", modulePath), err, err.Error(), true)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	output, err := runTerragrunt(ctx, fullPath, "show", "-json", "tfplan")
+	if err != nil {
+		return nil, fmt.Errorf("failed to show plan for module %s: %w", modulePath, err)
+	}
+
+	summary, err := parsePlan(modulePath, output)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Terragrunt plan completed", "module", modulePath, "add", summary.Add, "change", summary.Change, "destroy", summary.Destroy)
+	return summary, nil
+}
+
+func TerragruntApply(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*ApplyReport, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Running terragrunt apply", "module", modulePath, "stack", stack)
 
 	repoPath, err := Clone(ctx, repoUrl, revision)
 	if err != nil {
-		return fmt.Errorf("failed to ensure repository is available: %w", err)
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
 	}
 
 	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
 
-	cmd := exec.CommandContext(ctx, "terragrunt", "apply", "--backend-bootstrap", "--auto-approve")
+	return terragruntApply(ctx, modulePath, fullPath, "")
+}
+
+// terragruntApply runs terragrunt apply in a module directory and reports its progress from the UI event stream,
+// a saved plan file is applied as is, otherwise the apply plans again and approves automatically
+func terragruntApply(ctx context.Context, modulePath string, fullPath string, planFile string) (*ApplyReport, error) {
+	logger := activity.GetLogger(ctx)
+
+	args := []string{"apply", "--backend-bootstrap", "--auto-approve", "--tf-forward-stdout", "-json"}
+	if planFile != "" {
+		args = []string{"apply", "--backend-bootstrap", "--tf-forward-stdout", "-json", planFile}
+	}
+
+	var tracker *applyTracker
+	if err := waitForStateLock(ctx, modulePath, func() error {
+		var err error
+		tracker, err = streamApply(ctx, modulePath, fullPath, args)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	progress := tracker.progress()
+	safeHeartbeat(ctx, progress)
+	logger.Info("Terragrunt apply completed", "module", modulePath, "progress", progress.Message)
+
+	report := tracker.report()
+	// The apply already succeeded, failing here would only apply the module again
+	if output, err := runTerragrunt(ctx, fullPath, "output", "-json"); err != nil {
+		logger.Warn("Failed to read terragrunt outputs", "module", modulePath, "error", err)
+	} else if report.Outputs, err = parseOutputs(modulePath, output); err != nil {
+		logger.Warn("Failed to read terragrunt outputs", "module", modulePath, "error", err)
+	}
+
+	return report, nil
+}
+
+// streamApply runs terragrunt apply with args and tracks its UI event stream
+func streamApply(ctx context.Context, modulePath string, fullPath string, args []string) (*applyTracker, error) {
+	logger := activity.GetLogger(ctx)
+
+	cmd := exec.CommandContext(ctx, "terragrunt", args...)
 	cmd.Dir = fullPath
 
-	// Create pipes to capture output and send heartbeats
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start terragrunt apply: %w", err)
+		return nil, fmt.Errorf("failed to start terragrunt apply: %w", err)
 	}
 
-	// Monitor output and send heartbeats
-	done := make(chan error, 1)
+	// Stream the UI events, the channel is closed once stdout reaches EOF
+	lines := make(chan string)
 	go func() {
-		done <- cmd.Wait()
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
 	}()
 
-	// Send heartbeats while monitoring output
 	heartbeatTicker := time.NewTicker(25 * time.Second) // Send heartbeat every 25s (before 30s timeout)
 	defer heartbeatTicker.Stop()
 
-	var lastOutput string
-	outputScanner := bufio.NewScanner(stdout)
-	errorScanner := bufio.NewScanner(stderr)
-
-	for {
+	tracker := newApplyTracker(modulePath)
+	for lines != nil {
 		select {
-		case err := <-done:
-			if err != nil {
-				return fmt.Errorf("terragrunt apply failed for module %s: %w", modulePath, err)
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				continue
+			}
+			if !tracker.handle(line) && strings.TrimSpace(line) != "" {
+				logger.Info("Terragrunt output", "module", modulePath, "output", line)
 			}
-			safeHeartbeat(ctx, fmt.Sprintf("Terragrunt apply completed for %s", modulePath))
-			return nil
 
 		case <-heartbeatTicker.C:
-			safeHeartbeat(ctx, fmt.Sprintf("Terragrunt apply in progress for %s - %s", modulePath, lastOutput))
-
-		default:
-			// Check for new output
-			if outputScanner.Scan() {
-				line := strings.TrimSpace(outputScanner.Text())
-				if line != "" {
-					lastOutput = line
-					logger.Info("Terragrunt output", "module", modulePath, "output", line)
-				}
-			}
-			if errorScanner.Scan() {
-				line := strings.TrimSpace(errorScanner.Text())
-				if line != "" {
-					lastOutput = line
-					logger.Info("Terragrunt error output", "module", modulePath, "error", line)
-				}
-			}
+			safeHeartbeat(ctx, tracker.progress())
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		logger.Error("Terragrunt apply failed", "module", modulePath, "stderr", stderr.String())
+		message := fmt.Sprintf("terragrunt apply failed for module %s", modulePath)
+		if summary := tracker.errorSummary(); summary != "" {
+			err = fmt.Errorf("%w: %s", err, summary)
+		} else {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, classifyTerraformError(message, err, stderr.String()+"\n"+tracker.diagnosticsText(), !tracker.applying())
+	}
+	return tracker, nil
+}
+
+func TerragruntDestroy(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) error {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Running terragrunt destroy", "module", modulePath, "stack", stack)
+
+	repoPath, err := Clone(ctx, repoUrl, revision)
+	if err != nil {
+		return fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	if err := waitForStateLock(ctx, modulePath, func() error {
+		if _, err := runTerragrunt(ctx, fullPath, "destroy", "--backend-bootstrap", "--auto-approve"); err != nil {
+			return classifyTerraformError(fmt.Sprintf("terragrunt destroy failed for module %s", modulePath), err, err.Error(), false)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	safeHeartbeat(ctx, fmt.Sprintf("Terragrunt destroy completed for %s", modulePath))
+	return nil
+}
+
+// DriftReport lists the resources whose remote state no longer matches the configuration of a module.
+type DriftReport struct {
+	Module    string   `json:"module"`
+	Drifted   bool     `json:"drifted"`
+	Resources []string `json:"resources,omitempty"`
+}
 
-			// Small sleep to prevent busy waiting
-			time.Sleep(100 * time.Millisecond)
+func TerragruntDrift(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*DriftReport, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Checking terragrunt drift", "module", modulePath, "stack", stack)
+
+	// The plan file is written next to the module, an apply of the same revision must not see it
+	repoPath, err := CloneForRun(ctx, repoUrl, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure repository is available: %w", err)
+	}
+
+	fullPath := filepath.Join(repoPath, "infra", stack, modulePath)
+
+	// Exit code 2 means the plan succeeded and there are changes
+	report := &DriftReport{Module: modulePath}
+	if err := waitForStateLock(ctx, modulePath, func() error {
+		if _, err := runTerragrunt(ctx, fullPath, "plan", "--backend-bootstrap", "-detailed-exitcode", "-out=tfplan"); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 2 {
+				return classifyTerraformError(fmt.Sprintf("terragrunt plan failed for module %s", modulePath), err, err.Error(), true)
+			}
+			report.Drifted = true
 		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if !report.Drifted {
+		return report, nil
 	}
+
+	output, err := runTerragrunt(ctx, fullPath, "show", "-json", "tfplan")
+	if err != nil {
+		return nil, fmt.Errorf("failed to show plan for module %s: %w", modulePath, err)
+	}
+
+	summary, err := parsePlan(modulePath, output)
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range summary.Resources {
+		report.Resources = append(report.Resources, resource.Address)
+	}
+
+	logger.Info("Terragrunt drift detected", "module", modulePath, "resources", report.Resources)
+	return report, nil
 }
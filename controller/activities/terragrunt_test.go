@@ -0,0 +1,180 @@
+package activities
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.temporal.io/sdk/temporal"
+)
+
+func TestParsePlan(t *testing.T) {
+	plan := `{
+		"format_version": "1.2",
+		"resource_changes": [
+			{"address": "null_resource.create", "change": {"actions": ["create"]}},
+			{"address": "null_resource.update", "change": {"actions": ["update"]}},
+			{"address": "null_resource.delete", "change": {"actions": ["delete"]}},
+			{"address": "null_resource.replace", "change": {"actions": ["delete", "create"]}},
+			{"address": "null_resource.noop", "change": {"actions": ["no-op"]}},
+			{"address": "data.null_data_source.read", "change": {"actions": ["read"]}}
+		]
+	}`
+
+	summary, err := parsePlan("cluster", []byte(plan))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster", summary.Module)
+	assert.Equal(t, 2, summary.Add)
+	assert.Equal(t, 1, summary.Change)
+	assert.Equal(t, 2, summary.Destroy)
+	assert.True(t, summary.HasChanges())
+	assert.Len(t, summary.Resources, 4)
+	assert.Equal(t, "null_resource.replace", summary.Resources[3].Address)
+	assert.Equal(t, []string{"delete", "create"}, summary.Resources[3].Actions)
+}
+
+func TestParsePlan_NoChanges(t *testing.T) {
+	summary, err := parsePlan("bootstrap", []byte(`{"format_version": "1.2"}`))
+
+	assert.NoError(t, err)
+	assert.False(t, summary.HasChanges())
+	assert.Empty(t, summary.Resources)
+}
+
+func TestParsePlan_InvalidJSON(t *testing.T) {
+	_, err := parsePlan("bootstrap", []byte(`not json`))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bootstrap")
+}
+
+func TestParseOutputs(t *testing.T) {
+	output := `{
+		"name": {"sensitive": false, "type": "string", "value": "local"},
+		"nodes": {"sensitive": false, "type": ["list", "number"], "value": [1, 2]},
+		"credentials": {"sensitive": true, "type": ["object", {"host": "string"}], "value": {"host": "https://127.0.0.1:6443"}}
+	}`
+
+	outputs, err := parseOutputs("cluster", []byte(output))
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]ModuleOutput{
+		"name":        {Value: "local"},
+		"nodes":       {Value: []any{float64(1), float64(2)}},
+		"credentials": {Sensitive: true},
+	}, outputs)
+}
+
+func TestParseOutputs_InvalidJSON(t *testing.T) {
+	_, err := parseOutputs("cluster", []byte("not json"))
+
+	assert.ErrorContains(t, err, "failed to parse outputs for module cluster")
+}
+
+func TestClassifyTerraformError(t *testing.T) {
+	testCases := []struct {
+		name         string
+		output       string
+		planning     bool
+		expectedType string
+		nonRetryable bool
+	}{
+		{
+			name:         "state lock",
+			output:       "Error: Error acquiring the state lock\nLock Info:\n  ID: 1234",
+			expectedType: TerraformStateLockError,
+		},
+		{
+			name:         "provider auth",
+			output:       "Error: configuring S3 Backend: InvalidAccessKeyId: The access key ID you provided does not exist",
+			expectedType: TerraformProviderAuthError,
+			nonRetryable: true,
+		},
+		{
+			name:         "validation",
+			output:       "Error: Unsupported argument\n  on main.tf line 3: An argument named \"foo\" is not expected here.",
+			planning:     true,
+			expectedType: TerraformValidationError,
+			nonRetryable: true,
+		},
+		{
+			name:         "transient network",
+			output:       "Error: Get \"https://api.github.com\": dial tcp: lookup api.github.com: i/o timeout",
+			expectedType: TerraformTransientError,
+		},
+		{
+			name:         "transient api",
+			output:       "Error: creating instance: 503 Service Unavailable",
+			expectedType: TerraformTransientError,
+		},
+		{
+			name:         "invalid function argument",
+			output:       "Error: Invalid function argument\n  on main.tf line 4: Invalid value for \"path\" parameter: no file exists at \"missing.yaml\".",
+			planning:     true,
+			expectedType: TerraformValidationError,
+			nonRetryable: true,
+		},
+		{
+			name:         "known plan failure",
+			output:       "Error: Invalid count argument\n  on main.tf line 12: The \"count\" value depends on resource attributes that cannot be determined until apply",
+			planning:     true,
+			expectedType: TerraformPlanError,
+			nonRetryable: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := classifyTerraformError("terragrunt apply failed for module cluster", errors.New("exit status 1"), tc.output, tc.planning)
+
+			var applicationErr *temporal.ApplicationError
+			if assert.ErrorAs(t, err, &applicationErr) {
+				assert.Equal(t, tc.expectedType, applicationErr.Type())
+				assert.Equal(t, tc.nonRetryable, applicationErr.NonRetryable())
+			}
+			assert.Contains(t, err.Error(), "module cluster")
+		})
+	}
+}
+
+func TestClassifyTerraformError_Unclassified(t *testing.T) {
+	testCases := []struct {
+		name     string
+		output   string
+		planning bool
+	}{
+		{
+			name:   "during apply",
+			output: "Error: something unexpected",
+		},
+		{
+			name:     "during plan",
+			output:   "Error: something unexpected",
+			planning: true,
+		},
+		{
+			// Providers report bad API responses as invalid too, they may well succeed on the next attempt
+			name:     "provider diagnostic named invalid",
+			output:   "Error: Invalid response from API\n  invalid character '<' looking for beginning of value",
+			planning: true,
+		},
+		{
+			name:   "plan diagnostic during apply",
+			output: "Error: Invalid count argument",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cause := errors.New("exit status 1")
+
+			err := classifyTerraformError("terragrunt apply failed for module cluster", cause, tc.output, tc.planning)
+
+			// Plain errors are retried by the retry policy of the activity
+			var applicationErr *temporal.ApplicationError
+			assert.False(t, errors.As(err, &applicationErr))
+			assert.ErrorIs(t, err, cause)
+		})
+	}
+}
@@ -0,0 +1,416 @@
+package activities
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// discoverUnits returns the repository relative directories of every terragrunt unit under infra, sorted
+func discoverUnits(repoPath string) ([]string, error) {
+	root := filepath.Join(repoPath, "infra")
+	var units []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() && path != root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && entry.Name() == "terragrunt.hcl" {
+			dir, err := filepath.Rel(repoPath, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			units = append(units, filepath.ToSlash(dir))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover terragrunt units: %w", err)
+	}
+
+	sort.Strings(units)
+	return units, nil
+}
+
+// unitModule strips the infra/<stack> prefix from a unit directory to get the module path used in the graph
+func unitModule(unitDir string) string {
+	if parts := strings.Split(filepath.ToSlash(unitDir), "/"); len(parts) >= 3 && parts[0] == "infra" {
+		return strings.Join(parts[2:], "/")
+	}
+	return ""
+}
+
+// parseHCLFile parses a file with the native HCL syntax, both terragrunt and terraform configs use it
+func parseHCLFile(path string) (*hclsyntax.Body, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, diags := hclsyntax.ParseConfig(data, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, diags)
+	}
+
+	return file.Body.(*hclsyntax.Body), nil
+}
+
+// literalString returns the value of an attribute that can be evaluated without any context,
+// sources built from functions or variables are not resolved
+func literalString(body *hclsyntax.Body, name string) (string, bool) {
+	attr, ok := body.Attributes[name]
+	if !ok {
+		return "", false
+	}
+	return literalValue(attr.Expr)
+}
+
+func literalValue(expr hclsyntax.Expression) (string, bool) {
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() || value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+		return "", false
+	}
+
+	return value.AsString(), true
+}
+
+// localSourceDir resolves a local terraform source relative to dir, the "//" separating the
+// downloaded root from the subdirectory is dropped because only the subdirectory is used
+func localSourceDir(dir string, source string) (string, bool) {
+	if !strings.HasPrefix(source, "./") && !strings.HasPrefix(source, "../") {
+		return "", false
+	}
+
+	if i := strings.Index(source, "//"); i >= 0 {
+		source = source[:i] + "/" + source[i+2:]
+	}
+
+	return filepath.Join(dir, source), true
+}
+
+// unitSourceDirs returns the repository relative directories of a unit's local terraform source
+// and every local module called from there
+func unitSourceDirs(repoPath string, unitDir string) ([]string, error) {
+	body, err := parseHCLFile(filepath.Join(repoPath, unitDir, "terragrunt.hcl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, block := range body.Blocks {
+		if block.Type != "terraform" {
+			continue
+		}
+		source, ok := literalString(block.Body, "source")
+		if !ok {
+			continue
+		}
+		if moduleDir, ok := localSourceDir(unitDir, source); ok {
+			moduleDirs, err := moduleSourceDirs(repoPath, moduleDir, make(map[string]bool))
+			if err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, moduleDirs...)
+		}
+	}
+
+	return dirs, nil
+}
+
+// moduleSourceDirs returns a terraform module directory and the directories of the local modules it calls, recursively
+func moduleSourceDirs(repoPath string, moduleDir string, visited map[string]bool) ([]string, error) {
+	moduleDir = filepath.ToSlash(filepath.Clean(moduleDir))
+	if visited[moduleDir] || moduleDir == ".." || strings.HasPrefix(moduleDir, "../") {
+		return nil, nil
+	}
+	visited[moduleDir] = true
+
+	files, err := filepath.Glob(filepath.Join(repoPath, moduleDir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{moduleDir}
+	for _, file := range files {
+		body, err := parseHCLFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, block := range body.Blocks {
+			if block.Type != "module" {
+				continue
+			}
+			source, ok := literalString(block.Body, "source")
+			if !ok {
+				continue
+			}
+			if calledDir, ok := localSourceDir(moduleDir, source); ok {
+				calledDirs, err := moduleSourceDirs(repoPath, calledDir, visited)
+				if err != nil {
+					return nil, err
+				}
+				dirs = append(dirs, calledDirs...)
+			}
+		}
+	}
+
+	return dirs, nil
+}
+
+// configResolver collects the files read by a unit's terragrunt configuration. Like terragrunt does for includes,
+// functions are evaluated from the unit directory no matter which file they are written in.
+type configResolver struct {
+	repoPath string
+	unitDir  string
+	files    []string
+	seen     map[string]bool
+}
+
+// unitConfigFiles returns the repository relative files a unit's terragrunt.hcl reads, recursively: includes,
+// read_terragrunt_config, files found with find_in_parent_folders, decrypted files and the sops config next to them
+func unitConfigFiles(repoPath string, unitDir string) ([]string, error) {
+	resolver := &configResolver{
+		repoPath: repoPath,
+		unitDir:  unitDir,
+		seen:     make(map[string]bool),
+	}
+	if err := resolver.parse(filepath.ToSlash(filepath.Join(unitDir, "terragrunt.hcl"))); err != nil {
+		return nil, err
+	}
+
+	return resolver.files, nil
+}
+
+// add records a file and parses it if it is a terragrunt configuration
+func (r *configResolver) add(file string) error {
+	if r.seen[file] {
+		return nil
+	}
+	r.seen[file] = true
+	r.files = append(r.files, file)
+
+	if filepath.Ext(file) != ".hcl" {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(r.repoPath, file)); err != nil {
+		return nil
+	}
+	return r.parse(file)
+}
+
+func (r *configResolver) parse(file string) error {
+	r.seen[file] = true
+
+	body, err := parseHCLFile(filepath.Join(r.repoPath, file))
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, block := range body.Blocks {
+		if block.Type != "include" {
+			continue
+		}
+		if attr, ok := block.Body.Attributes["path"]; ok {
+			if path, ok := r.resolvePath(attr.Expr); ok {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	hclsyntax.VisitAll(body, func(node hclsyntax.Node) hcl.Diagnostics {
+		call, ok := node.(*hclsyntax.FunctionCallExpr)
+		if !ok {
+			return nil
+		}
+		switch call.Name {
+		case "find_in_parent_folders":
+			if path, ok := r.resolvePath(call); ok {
+				paths = append(paths, path)
+			}
+		case "read_terragrunt_config", "file":
+			if len(call.Args) > 0 {
+				if path, ok := r.resolvePath(call.Args[0]); ok {
+					paths = append(paths, path)
+				}
+			}
+		case "sops_decrypt_file":
+			if len(call.Args) > 0 {
+				if path, ok := r.resolvePath(call.Args[0]); ok {
+					paths = append(paths, path)
+					if sopsConfig, ok := r.findUp(filepath.Dir(path), ".sops.yaml"); ok {
+						paths = append(paths, sopsConfig)
+					}
+				}
+			}
+		}
+		return nil
+	})
+
+	for _, path := range paths {
+		if err := r.add(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolvePath evaluates a path argument, either a literal relative to the unit directory or a find_in_parent_folders call
+func (r *configResolver) resolvePath(expr hclsyntax.Expression) (string, bool) {
+	if call, ok := expr.(*hclsyntax.FunctionCallExpr); ok {
+		if call.Name != "find_in_parent_folders" {
+			return "", false
+		}
+		name := "terragrunt.hcl"
+		if len(call.Args) > 0 {
+			value, ok := literalValue(call.Args[0])
+			if !ok {
+				return "", false
+			}
+			name = value
+		}
+		return r.findUp(filepath.Dir(r.unitDir), name)
+	}
+
+	path, ok := literalValue(expr)
+	if !ok || filepath.IsAbs(path) {
+		return "", false
+	}
+	path = filepath.ToSlash(filepath.Join(r.unitDir, path))
+	if path == ".." || strings.HasPrefix(path, "../") {
+		return "", false
+	}
+	return path, true
+}
+
+// findUp searches dir and its parents up to the repository root for a file
+func (r *configResolver) findUp(dir string, name string) (string, bool) {
+	for ; ; dir = filepath.Dir(dir) {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(filepath.Join(r.repoPath, path)); err == nil {
+			return filepath.ToSlash(path), true
+		}
+		if dir == "." || dir == "/" {
+			return "", false
+		}
+	}
+}
+
+// isUnder reports whether a repository relative file is inside dir or one of its subdirectories
+func isUnder(file string, dir string) bool {
+	return strings.HasPrefix(filepath.ToSlash(file), dir+"/")
+}
+
+// owningUnit returns the nearest directory above a repository relative file that contains a terragrunt.hcl
+func owningUnit(repoPath string, file string) (string, bool) {
+	for dir := filepath.Dir(file); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(repoPath, dir, "terragrunt.hcl")); err == nil {
+			return filepath.ToSlash(dir), true
+		}
+	}
+	return "", false
+}
+
+// ChangeSet lists the modules affected by a change, each with the first changed file that affects it
+type ChangeSet struct {
+	Modules []string          `json:"modules"`
+	Files   map[string]string `json:"files,omitempty"`
+}
+
+// changedUnits maps changed files to the modules of the units of a stack they affect. A file affects the unit it
+// belongs to, every unit with the file anywhere in the source tree of its terraform module and every unit whose
+// configuration reads it, like a shared root.hcl or secrets file. Lock files only ever affect the unit that owns
+// them. Module paths are only unique within a stack, so units of other stacks are left out, unless stack is empty
+// for activities scheduled before the stack was passed.
+func changedUnits(repoPath string, stack string, files []string) (*ChangeSet, error) {
+	inStack := func(unitDir string) bool {
+		return stack == "" || isUnder(unitDir, "infra/"+stack)
+	}
+	changes := &ChangeSet{Files: make(map[string]string)}
+	mark := func(unitDir string, file string) {
+		if !inStack(unitDir) {
+			return
+		}
+		if module := unitModule(unitDir); module != "" {
+			if _, seen := changes.Files[module]; !seen {
+				changes.Files[module] = file
+				changes.Modules = append(changes.Modules, module)
+			}
+		}
+	}
+
+	for _, file := range files {
+		if unitDir, ok := owningUnit(repoPath, file); ok {
+			mark(unitDir, file)
+		}
+	}
+
+	units, err := discoverUnits(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var sharedFiles []string
+	for _, file := range files {
+		if filepath.Base(file) != ".terraform.lock.hcl" {
+			sharedFiles = append(sharedFiles, filepath.ToSlash(file))
+		}
+	}
+
+	for _, unitDir := range units {
+		if !inStack(unitDir) {
+			continue
+		}
+		dirs, err := unitSourceDirs(repoPath, unitDir)
+		if err != nil {
+			return nil, err
+		}
+		configFiles, err := unitConfigFiles(repoPath, unitDir)
+		if err != nil {
+			return nil, err
+		}
+		if file, ok := touchesAny(sharedFiles, dirs); ok {
+			mark(unitDir, file)
+		} else if file, ok := containsAnyFile(sharedFiles, configFiles); ok {
+			mark(unitDir, file)
+		}
+	}
+
+	sort.Strings(changes.Modules)
+	return changes, nil
+}
+
+func touchesAny(files []string, dirs []string) (string, bool) {
+	for _, file := range files {
+		for _, dir := range dirs {
+			if isUnder(file, dir) {
+				return file, true
+			}
+		}
+	}
+	return "", false
+}
+
+func containsAnyFile(files []string, candidates []string) (string, bool) {
+	for _, file := range files {
+		for _, candidate := range candidates {
+			if file == candidate {
+				return file, true
+			}
+		}
+	}
+	return "", false
+}
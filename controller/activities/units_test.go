@@ -0,0 +1,411 @@
+package activities
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRepo creates a repository layout from repository relative paths and their contents
+func writeRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	repoPath := t.TempDir()
+	for path, content := range files {
+		fullPath := filepath.Join(repoPath, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0o755))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), 0o644))
+	}
+	return repoPath
+}
+
+func sharedModulesRepo(t *testing.T) string {
+	return writeRepo(t, map[string]string{
+		"infra/local/root.hcl": ``,
+		"infra/local/cluster/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+
+terraform {
+  source = "../../modules/local-cluster"
+}
+`,
+		"infra/local/bootstrap/terragrunt.hcl": `
+terraform {
+  source = "../../modules/bootstrap"
+}
+
+dependency "cluster" {
+  config_path = "../cluster"
+}
+`,
+		"infra/production/oracle/legacy/terragrunt.hcl": `
+terraform {
+  source = "../../../modules//legacy"
+}
+`,
+		"infra/production/metal/cluster/terragrunt.hcl": `
+terraform {
+  source = "../../../modules//empty"
+}
+`,
+		"infra/production/remote/terragrunt.hcl": `
+terraform {
+  source = "git::https://github.com/example/modules.git//network?ref=v1.0.0"
+}
+`,
+		"infra/production/dynamic/terragrunt.hcl": `
+terraform {
+  source = "${get_repo_root()}/infra/modules/network"
+}
+`,
+		"infra/modules/local-cluster/main.tf": `resource "k3d_cluster" "main" {}`,
+		"infra/modules/bootstrap/argocd.tf":   `resource "helm_release" "argocd" {}`,
+		"infra/modules/empty/variables.tf":    ``,
+		"infra/modules/legacy/main.tf": `
+module "network" {
+  source = "../network"
+}
+
+module "cluster" {
+  source = "../cluster"
+}
+
+module "registry" {
+  source  = "hashicorp/example/registry"
+  version = "1.0.0"
+}
+`,
+		"infra/modules/network/main.tf":            `resource "oci_core_vcn" "main" {}`,
+		"infra/modules/cluster/main.tf":            `module "base" { source = "./base" }`,
+		"infra/modules/cluster/base/main.tf":       ``,
+		"infra/modules/cluster/roles/k3s/main.yml": ``,
+	})
+}
+
+func TestDiscoverUnits(t *testing.T) {
+	repoPath := sharedModulesRepo(t)
+
+	units, err := discoverUnits(repoPath)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"infra/local/bootstrap",
+		"infra/local/cluster",
+		"infra/production/dynamic",
+		"infra/production/metal/cluster",
+		"infra/production/oracle/legacy",
+		"infra/production/remote",
+	}, units)
+}
+
+func TestUnitSourceDirs(t *testing.T) {
+	repoPath := sharedModulesRepo(t)
+
+	dirs, err := unitSourceDirs(repoPath, "infra/production/oracle/legacy")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"infra/modules/legacy",
+		"infra/modules/network",
+		"infra/modules/cluster",
+		"infra/modules/cluster/base",
+	}, dirs)
+
+	// Remote and computed sources cannot be resolved locally
+	dirs, err = unitSourceDirs(repoPath, "infra/production/remote")
+	require.NoError(t, err)
+	assert.Empty(t, dirs)
+	dirs, err = unitSourceDirs(repoPath, "infra/production/dynamic")
+	require.NoError(t, err)
+	assert.Empty(t, dirs)
+}
+
+func TestChangedUnits(t *testing.T) {
+	repoPath := sharedModulesRepo(t)
+
+	testCases := []struct {
+		name     string
+		stack    string
+		files    []string
+		expected []string
+	}{
+		{
+			name:     "unit file",
+			stack:    "local",
+			files:    []string{"infra/local/cluster/terragrunt.hcl"},
+			expected: []string{"cluster"},
+		},
+		{
+			name:     "module used by a unit",
+			stack:    "local",
+			files:    []string{"infra/modules/bootstrap/argocd.tf"},
+			expected: []string{"bootstrap"},
+		},
+		{
+			name:     "module behind a double slash source",
+			stack:    "production",
+			files:    []string{"infra/modules/empty/variables.tf"},
+			expected: []string{"metal/cluster"},
+		},
+		{
+			name:     "non terraform file nested in a module called by another module",
+			stack:    "production",
+			files:    []string{"infra/modules/cluster/roles/k3s/main.yml"},
+			expected: []string{"oracle/legacy"},
+		},
+		{
+			name:     "module of a module of a module",
+			stack:    "production",
+			files:    []string{"infra/modules/cluster/base/main.tf"},
+			expected: []string{"oracle/legacy"},
+		},
+		{
+			name:     "unused module",
+			stack:    "local",
+			files:    []string{"infra/modules/unused/main.tf"},
+			expected: nil,
+		},
+		{
+			name:     "outside infra",
+			stack:    "local",
+			files:    []string{"README.md", "controller/main.go"},
+			expected: nil,
+		},
+		{
+			name:  "several files",
+			stack: "local",
+			files: []string{
+				"infra/modules/local-cluster/main.tf",
+				"infra/modules/network/main.tf",
+				"infra/local/bootstrap/terragrunt.hcl",
+			},
+			expected: []string{"bootstrap", "cluster"},
+		},
+		{
+			name:  "several files in another stack",
+			stack: "production",
+			files: []string{
+				"infra/modules/local-cluster/main.tf",
+				"infra/modules/network/main.tf",
+				"infra/local/bootstrap/terragrunt.hcl",
+			},
+			expected: []string{"oracle/legacy"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changes, err := changedUnits(repoPath, tc.stack, tc.files)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, changes.Modules)
+		})
+	}
+}
+
+func TestChangedUnits_Files(t *testing.T) {
+	repoPath := sharedModulesRepo(t)
+
+	changes, err := changedUnits(repoPath, "local", []string{
+		"infra/local/bootstrap/terragrunt.hcl",
+		"infra/modules/local-cluster/main.tf",
+		"infra/local/root.hcl",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bootstrap", "cluster"}, changes.Modules)
+	assert.Equal(t, map[string]string{
+		"bootstrap": "infra/local/bootstrap/terragrunt.hcl",
+		"cluster":   "infra/modules/local-cluster/main.tf",
+	}, changes.Files)
+}
+
+func TestChangedUnits_SameModuleInTwoStacks(t *testing.T) {
+	repoPath := writeRepo(t, map[string]string{
+		"infra/local/cluster/terragrunt.hcl": `
+terraform {
+  source = "../../modules/local-cluster"
+}
+`,
+		"infra/production/cluster/terragrunt.hcl": `
+terraform {
+  source = "../../modules/cluster"
+}
+`,
+		"infra/modules/local-cluster/main.tf": ``,
+		"infra/modules/cluster/main.tf":       ``,
+	})
+
+	// Both stacks have a cluster module, a change to the local one must not apply the production one
+	changes, err := changedUnits(repoPath, "production", []string{
+		"infra/local/cluster/terragrunt.hcl",
+		"infra/modules/local-cluster/main.tf",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, changes.Modules)
+
+	changes, err = changedUnits(repoPath, "local", []string{"infra/local/cluster/terragrunt.hcl"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cluster"}, changes.Modules)
+
+	changes, err = changedUnits(repoPath, "production", []string{"infra/modules/cluster/main.tf"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"cluster": "infra/modules/cluster/main.tf"}, changes.Files)
+}
+
+func TestChangedUnits_InvalidConfig(t *testing.T) {
+	repoPath := writeRepo(t, map[string]string{
+		"infra/local/cluster/terragrunt.hcl": `terraform {`,
+	})
+
+	_, err := changedUnits(repoPath, "local", []string{"infra/local/cluster/terragrunt.hcl"})
+
+	assert.ErrorContains(t, err, "terragrunt.hcl")
+}
+
+func stackFilesRepo(t *testing.T) string {
+	return writeRepo(t, map[string]string{
+		"infra/production/.sops.yaml":   `creation_rules: []`,
+		"infra/production/secrets.yaml": `token: ENC[]`,
+		"infra/production/root.hcl": `
+locals {
+  secrets = yamldecode(sops_decrypt_file(find_in_parent_folders("secrets.yaml")))
+  common  = read_terragrunt_config(find_in_parent_folders("common.hcl"))
+}
+`,
+		"infra/production/common.hcl": `
+locals {
+  region = file("../region.txt")
+}
+`,
+		"infra/production/metal/region.txt": `vn-south-1`,
+		"infra/production/metal/cluster/terragrunt.hcl": `
+include "root" {
+  path   = find_in_parent_folders("root.hcl")
+  expose = true
+}
+`,
+		"infra/production/metal/cluster/.terraform.lock.hcl": ``,
+		"infra/production/metal/bootstrap/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+
+terraform {
+  source = "../../../modules//bootstrap"
+}
+`,
+		"infra/production/oracle/legacy/terragrunt.hcl": `
+include "root" {
+  path = "../../root.hcl"
+}
+`,
+		"infra/production/standalone/terragrunt.hcl": `
+terraform {
+  source = "../../modules//empty"
+}
+`,
+		"infra/modules/bootstrap/main.tf":             ``,
+		"infra/modules/bootstrap/.terraform.lock.hcl": ``,
+		"infra/local/root.hcl":                        ``,
+		"infra/local/cluster/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+`,
+	})
+}
+
+func TestUnitConfigFiles(t *testing.T) {
+	repoPath := stackFilesRepo(t)
+
+	files, err := unitConfigFiles(repoPath, "infra/production/metal/cluster")
+
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"infra/production/root.hcl",
+		"infra/production/secrets.yaml",
+		"infra/production/.sops.yaml",
+		"infra/production/common.hcl",
+		"infra/production/metal/region.txt",
+	}, files)
+}
+
+func TestChangedUnits_StackFiles(t *testing.T) {
+	repoPath := stackFilesRepo(t)
+	production := []string{"metal/bootstrap", "metal/cluster", "oracle/legacy"}
+
+	testCases := []struct {
+		name     string
+		stack    string
+		files    []string
+		expected []string
+	}{
+		{
+			name:     "root include",
+			stack:    "production",
+			files:    []string{"infra/production/root.hcl"},
+			expected: production,
+		},
+		{
+			name:     "secrets decrypted by the root include",
+			stack:    "production",
+			files:    []string{"infra/production/secrets.yaml"},
+			expected: production,
+		},
+		{
+			name:     "sops config of the decrypted secrets",
+			stack:    "production",
+			files:    []string{"infra/production/.sops.yaml"},
+			expected: production,
+		},
+		{
+			name:     "config read by the root include",
+			stack:    "production",
+			files:    []string{"infra/production/common.hcl"},
+			expected: production,
+		},
+		{
+			name:     "file resolved from the unit directory",
+			stack:    "production",
+			files:    []string{"infra/production/metal/region.txt"},
+			expected: []string{"metal/bootstrap", "metal/cluster"},
+		},
+		{
+			name:     "root include of the local stack",
+			stack:    "local",
+			files:    []string{"infra/local/root.hcl"},
+			expected: []string{"cluster"},
+		},
+		{
+			name:     "root include of another stack",
+			stack:    "production",
+			files:    []string{"infra/local/root.hcl"},
+			expected: nil,
+		},
+		{
+			name:     "unit lock file",
+			stack:    "production",
+			files:    []string{"infra/production/metal/cluster/.terraform.lock.hcl"},
+			expected: []string{"metal/cluster"},
+		},
+		{
+			name:     "lock file in a shared module",
+			stack:    "production",
+			files:    []string{"infra/modules/bootstrap/.terraform.lock.hcl"},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changes, err := changedUnits(repoPath, tc.stack, tc.files)
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, changes.Modules)
+		})
+	}
+}
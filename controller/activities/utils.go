@@ -7,7 +7,7 @@ import (
 )
 
 // safeHeartbeat sends a heartbeat only if we're in an activity context
-func safeHeartbeat(ctx context.Context, details string) {
+func safeHeartbeat(ctx context.Context, details interface{}) {
 	defer func() {
 		if r := recover(); r != nil {
 			// Ignore panic - we're not in an activity context
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"cloudlab/controller/activities"
 	"cloudlab/controller/workflows"
 
+	"go.temporal.io/api/enums/v1"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/worker"
 )
 
@@ -24,10 +31,30 @@ func main() {
 	w := worker.New(temporalClient, "cloudlab", worker.Options{})
 
 	w.RegisterActivity(activities.Clone)
+	w.RegisterActivity(activities.CloneForRun)
 	w.RegisterActivity(activities.ChangedModules)
+	w.RegisterActivity(activities.DetectChanges)
+	w.RegisterActivity(activities.RemovedModules)
 	w.RegisterActivity(activities.TerragruntGraph)
+	w.RegisterActivity(activities.HCLGraph)
 	w.RegisterActivity(activities.PruneGraph)
+	w.RegisterActivity(activities.PruneChanges)
+	w.RegisterActivity(activities.TerragruntPlan)
+	w.RegisterActivity(activities.TerragruntPlanDestroy)
 	w.RegisterActivity(activities.TerragruntApply)
+	w.RegisterActivity(activities.TerragruntDestroy)
+	w.RegisterActivity(activities.TerragruntSavePlan)
+	w.RegisterActivity(activities.TerragruntApplyPlan)
+	w.RegisterActivity(activities.SavePlanSet)
+	w.RegisterActivity(activities.LoadPlanSet)
+	w.RegisterActivity(activities.TerragruntDrift)
+	w.RegisterActivity(activities.TerragruntLockInfo)
+	w.RegisterActivity(activities.TerragruntForceUnlock)
+	w.RegisterActivity(activities.StateLockHolder)
+	w.RegisterActivity(activities.RequestStackLock)
+	w.RegisterActivity(activities.WorkflowRunning)
+	w.RegisterActivity(activities.BackupState)
+	w.RegisterActivity(activities.RestoreStateSnapshot)
 	w.RegisterActivity(activities.PushManifests)
 	w.RegisterActivity(activities.PushRenderedApp)
 	w.RegisterActivity(activities.DiscoverApps)
@@ -37,12 +64,105 @@ func main() {
 	w.RegisterActivity(activities.GitPush)
 
 	w.RegisterWorkflow(workflows.Infra)
+	w.RegisterWorkflow(workflows.InfraDestroy)
+	w.RegisterWorkflow(workflows.DriftDetection)
+	w.RegisterWorkflow(workflows.ForceUnlock)
+	w.RegisterWorkflow(workflows.StackLock)
+	w.RegisterWorkflow(workflows.RestoreState)
 	w.RegisterWorkflow(workflows.Platform)
 	w.RegisterWorkflow(workflows.Apps)
 	w.RegisterWorkflow(workflows.AppUpdate)
 
+	// Drift detection is optional, the other workflows are served even if its schedules are misconfigured
+	if err := createDriftSchedules(temporalClient); err != nil {
+		log.Println("Unable to create drift detection schedules", err)
+	}
+
 	err = w.Run(worker.InterruptCh())
 	if err != nil {
 		log.Fatalln("Unable to start Worker", err)
 	}
 }
+
+// createDriftSchedules schedules a DriftDetection run for every stack in DRIFT_DETECTION_STACKS, schedules created
+// by an earlier start are updated to the current configuration. A stack that fails does not stop the others.
+func createDriftSchedules(temporalClient client.Client) error {
+	stacks := os.Getenv("DRIFT_DETECTION_STACKS")
+	if stacks == "" {
+		return nil
+	}
+
+	url := os.Getenv("DRIFT_DETECTION_URL")
+	if url == "" {
+		return errors.New("DRIFT_DETECTION_URL must be set when DRIFT_DETECTION_STACKS is")
+	}
+
+	interval := 6 * time.Hour
+	if value := os.Getenv("DRIFT_DETECTION_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid DRIFT_DETECTION_INTERVAL: %w", err)
+		}
+		interval = parsed
+	}
+
+	revision := os.Getenv("DRIFT_DETECTION_REVISION")
+	if revision == "" {
+		revision = "master"
+	}
+
+	ctx := context.Background()
+	var errs []error
+	for _, stack := range strings.Split(stacks, ",") {
+		stack = strings.TrimSpace(stack)
+		id := "drift-detection-" + strings.ReplaceAll(stack, "/", "-")
+
+		spec := client.ScheduleSpec{
+			Intervals: []client.ScheduleIntervalSpec{{Every: interval}},
+		}
+		action := &client.ScheduleWorkflowAction{
+			ID:        id,
+			Workflow:  workflows.DriftDetection,
+			TaskQueue: "cloudlab",
+			Args: []interface{}{workflows.DriftDetectionInputs{
+				Url:      url,
+				Revision: revision,
+				Stack:    stack,
+			}},
+		}
+
+		_, err := temporalClient.ScheduleClient().Create(ctx, client.ScheduleOptions{
+			ID:      id,
+			Spec:    spec,
+			Action:  action,
+			Overlap: enums.SCHEDULE_OVERLAP_POLICY_SKIP,
+		})
+		if errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+			err = temporalClient.ScheduleClient().GetHandle(ctx, id).Update(ctx, client.ScheduleUpdateOptions{
+				DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+					schedule := input.Description.Schedule
+					schedule.Spec = &spec
+					schedule.Action = action
+					if schedule.Policy == nil {
+						schedule.Policy = &client.SchedulePolicies{}
+					}
+					schedule.Policy.Overlap = enums.SCHEDULE_OVERLAP_POLICY_SKIP
+					return &client.ScheduleUpdate{Schedule: &schedule}, nil
+				},
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to update schedule %s: %w", id, err))
+				continue
+			}
+			log.Println("Updated drift detection schedule", id, "every", interval)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to create schedule %s: %w", id, err))
+			continue
+		}
+		log.Println("Created drift detection schedule", id, "every", interval)
+	}
+
+	return errors.Join(errs...)
+}
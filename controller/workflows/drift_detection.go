@@ -0,0 +1,102 @@
+package workflows
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+type DriftDetectionInputs struct {
+	Url      string
+	Revision string
+	Stack    string
+}
+
+type DriftDetectionResult struct {
+	// Drifted lists the modules with drift, sorted by module path
+	Drifted []string
+	Reports map[string]*activities.DriftReport
+	// Failed is keyed by module path for modules that could not be planned
+	Failed map[string]string
+}
+
+// DriftDetection plans every module of a stack and reports which ones no longer match their remote state
+func DriftDetection(ctx workflow.Context, input DriftDetectionInputs) (*DriftDetectionResult, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("DriftDetection workflow started", "infra", input)
+
+	cloneCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 1 * time.Minute,
+	})
+
+	var workspace string
+	if workflow.GetVersion(ctx, "drift-checkout", workflow.DefaultVersion, 1) == 1 {
+		// Drift runs on its own checkout, the one of Infra is in use by applies of the same revision
+		if err := workflow.ExecuteActivity(cloneCtx, activities.CloneForRun, input.Url, input.Revision).Get(ctx, &workspace); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(workspace)
+	} else if err := workflow.ExecuteActivity(cloneCtx, activities.Clone, input.Url, input.Revision).Get(ctx, &workspace); err != nil {
+		return nil, err
+	}
+
+	analysisCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 1,
+		},
+	})
+
+	graph, err := stackGraph(ctx, analysisCtx, workspace+"/infra/"+input.Stack)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := graph.GetNodes()
+	sort.Strings(modules)
+
+	var futures []workflow.Future
+	for _, module := range modules {
+		moduleCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: moduleTimeout,
+			HeartbeatTimeout:    2 * time.Minute,
+			Summary:             fmt.Sprintf("drift %s/%s", input.Stack, module),
+			RetryPolicy: &temporal.RetryPolicy{
+				MaximumAttempts: 2,
+				NonRetryableErrorTypes: []string{
+					activities.TerraformValidationError,
+					activities.TerraformPlanError,
+					activities.TerraformProviderAuthError,
+				},
+			},
+		})
+		futures = append(futures, workflow.ExecuteActivity(moduleCtx, activities.TerragruntDrift, input.Url, input.Revision, module, input.Stack))
+	}
+
+	result := &DriftDetectionResult{
+		Reports: make(map[string]*activities.DriftReport),
+		Failed:  make(map[string]string),
+	}
+	for i, future := range futures {
+		var report *activities.DriftReport
+		if err := future.Get(ctx, &report); err != nil {
+			logger.Error("TerragruntDrift failed", "module", modules[i], "error", err)
+			result.Failed[modules[i]] = err.Error()
+			continue
+		}
+		result.Reports[modules[i]] = report
+		if report.Drifted {
+			logger.Warn("Drift detected", "module", modules[i], "resources", report.Resources)
+			result.Drifted = append(result.Drifted, modules[i])
+		}
+	}
+
+	logger.Info("DriftDetection workflow completed", "modules", len(modules), "drifted", len(result.Drifted), "failed", len(result.Failed))
+	return result, nil
+}
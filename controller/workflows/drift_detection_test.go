@@ -0,0 +1,129 @@
+package workflows
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/testsuite"
+)
+
+type DriftDetectionWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+}
+
+func (s *DriftDetectionWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.SetTestTimeout(30 * time.Second)
+}
+
+func (s *DriftDetectionWorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+func (s *DriftDetectionWorkflowTestSuite) TestDriftDetection_ReportsDriftedModules() {
+	input := DriftDetectionInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "production",
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"metal/vn-south-1/cluster":   true,
+			"metal/vn-south-1/bootstrap": true,
+			"oracle/legacy":              true,
+		},
+		Edges: map[string][]string{
+			"metal/vn-south-1/bootstrap": {"metal/vn-south-1/cluster"},
+		},
+	}
+
+	s.env.OnActivity(activities.CloneForRun, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntDrift, mock.Anything, input.Url, input.Revision, "metal/vn-south-1/cluster", input.Stack).Return(
+		&activities.DriftReport{Module: "metal/vn-south-1/cluster"}, nil)
+	s.env.OnActivity(activities.TerragruntDrift, mock.Anything, input.Url, input.Revision, "metal/vn-south-1/bootstrap", input.Stack).Return(
+		(*activities.DriftReport)(nil), errors.New("terragrunt plan failed"))
+	s.env.OnActivity(activities.TerragruntDrift, mock.Anything, input.Url, input.Revision, "oracle/legacy", input.Stack).Return(
+		&activities.DriftReport{Module: "oracle/legacy", Drifted: true, Resources: []string{"oci_core_instance.legacy"}}, nil)
+
+	s.env.ExecuteWorkflow(DriftDetection, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result *DriftDetectionResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal([]string{"oracle/legacy"}, result.Drifted)
+	s.Equal([]string{"oci_core_instance.legacy"}, result.Reports["oracle/legacy"].Resources)
+	s.False(result.Reports["metal/vn-south-1/cluster"].Drifted)
+	s.Contains(result.Failed["metal/vn-south-1/bootstrap"], "terragrunt plan failed")
+}
+
+func (s *DriftDetectionWorkflowTestSuite) TestDriftDetection_GraphFailure() {
+	input := DriftDetectionInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "production",
+	}
+	repoPath := "/tmp/infra-12345"
+
+	s.env.OnActivity(activities.CloneForRun, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(
+		(*activities.Graph)(nil), errors.New("terragrunt dag graph failed"))
+
+	s.env.ExecuteWorkflow(DriftDetection, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "terragrunt dag graph failed")
+}
+
+func (s *DriftDetectionWorkflowTestSuite) TestDriftDetection_OwnCheckoutDuringApply() {
+	input := DriftDetectionInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "master",
+		Stack:    "local",
+	}
+	// An Infra apply of the same url and revision is in progress on the shared checkout
+	infraWorkspace := s.T().TempDir()
+	infraPlan := filepath.Join(infraWorkspace, "infra/local/cluster/tfplan")
+	s.Require().NoError(os.MkdirAll(filepath.Dir(infraPlan), 0o755))
+	s.Require().NoError(os.WriteFile(infraPlan, []byte("apply plan"), 0o644))
+	driftWorkspace := filepath.Join(s.T().TempDir(), "drift")
+	s.Require().NoError(os.MkdirAll(driftWorkspace, 0o755))
+
+	graph := activities.NewGraph()
+	graph.AddNode("cluster")
+
+	s.env.OnActivity(activities.Clone, mock.Anything, mock.Anything, mock.Anything).Return(infraWorkspace, nil).Never()
+	s.env.OnActivity(activities.CloneForRun, mock.Anything, input.Url, input.Revision).Return(driftWorkspace, nil).Once()
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, driftWorkspace+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntDrift, mock.Anything, input.Url, input.Revision, "cluster", input.Stack).Return(
+		&activities.DriftReport{Module: "cluster"}, nil)
+
+	s.env.ExecuteWorkflow(DriftDetection, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	// Drift cleans up its own checkout and leaves the apply alone
+	s.NoDirExists(driftWorkspace)
+	plan, err := os.ReadFile(infraPlan)
+	s.Require().NoError(err)
+	s.Equal("apply plan", string(plan))
+}
+
+func TestDriftDetectionWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(DriftDetectionWorkflowTestSuite))
+}
@@ -0,0 +1,83 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+type ForceUnlockInputs struct {
+	Url      string
+	Revision string
+	Stack    string
+	Module   string
+	// Confirm must be set to the ID of the lock to release, as reported by the run that was refused
+	Confirm string
+}
+
+// ForceUnlock releases a state lock left behind by a run that died, it refuses while a running workflow holds it
+func ForceUnlock(ctx workflow.Context, input ForceUnlockInputs) (*activities.StateLock, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("ForceUnlock workflow started", "unlock", input)
+
+	if input.Module == "" {
+		return nil, temporal.NewNonRetryableApplicationError("module is required", "InvalidInput", nil)
+	}
+	if input.Confirm == "" {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unlocking module %q requires confirm to be set to the lock ID", input.Module),
+			"ConfirmationRequired",
+			nil,
+		)
+	}
+
+	moduleCtx := moduleContext(ctx, input.Stack, input.Module)
+
+	var lock *activities.StateLock
+	if err := workflow.ExecuteActivity(moduleCtx, activities.TerragruntLockInfo, input.Url, input.Revision, input.Module, input.Stack).Get(ctx, &lock); err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("state of module %q in stack %q is not locked", input.Module, input.Stack),
+			"InvalidInput",
+			nil,
+		)
+	}
+	// The lock may have been released and taken again since it was reported
+	if lock.ID != input.Confirm {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("state of module %q is locked by %s with lock ID %s, not %s", input.Module, lock.Who, lock.ID, input.Confirm),
+			"ConfirmationRequired",
+			nil,
+		)
+	}
+
+	holderCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 1 * time.Minute,
+	})
+	var holder *activities.LockHolder
+	if err := workflow.ExecuteActivity(holderCtx, activities.StateLockHolder, lock).Get(ctx, &holder); err != nil {
+		return nil, err
+	}
+	if holder != nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("lock %s is held by running workflow %s (%s)", lock.ID, holder.WorkflowID, holder.Activity),
+			activities.TerraformStateLockError,
+			nil,
+			holder,
+		)
+	}
+
+	logger.Warn("Releasing state lock", "module", input.Module, "lock", lock.ID, "who", lock.Who, "created", lock.Created)
+	if err := workflow.ExecuteActivity(moduleCtx, activities.TerragruntForceUnlock, input.Url, input.Revision, input.Module, input.Stack, lock.ID).Get(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	logger.Info("ForceUnlock workflow completed", "module", input.Module, "lock", lock.ID)
+	return lock, nil
+}
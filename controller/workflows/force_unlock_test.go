@@ -0,0 +1,135 @@
+package workflows
+
+import (
+	"testing"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+type ForceUnlockWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+}
+
+func (s *ForceUnlockWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.SetTestTimeout(30 * time.Second)
+}
+
+func (s *ForceUnlockWorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+func (s *ForceUnlockWorkflowTestSuite) input() ForceUnlockInputs {
+	return ForceUnlockInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "production",
+		Module:   "cluster",
+		Confirm:  "6a7c5a2b",
+	}
+}
+
+func (s *ForceUnlockWorkflowTestSuite) lock() *activities.StateLock {
+	return &activities.StateLock{
+		ID:      "6a7c5a2b",
+		Path:    "tfstate/cluster/terraform.tfstate",
+		Who:     "root@worker-7d9f",
+		Created: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func (s *ForceUnlockWorkflowTestSuite) TestForceUnlockWorkflow_Success() {
+	input := s.input()
+	lock := s.lock()
+
+	s.env.OnActivity(activities.TerragruntLockInfo, mock.Anything, input.Url, input.Revision, input.Module, input.Stack).Return(lock, nil).Once()
+	s.env.OnActivity(activities.StateLockHolder, mock.Anything, lock).Return(nil, nil).Once()
+	s.env.OnActivity(activities.TerragruntForceUnlock, mock.Anything, input.Url, input.Revision, input.Module, input.Stack, lock.ID).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(ForceUnlock, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result *activities.StateLock
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(lock, result)
+}
+
+func (s *ForceUnlockWorkflowTestSuite) TestForceUnlockWorkflow_HolderRunning() {
+	input := s.input()
+	lock := s.lock()
+
+	s.env.OnActivity(activities.TerragruntLockInfo, mock.Anything, input.Url, input.Revision, input.Module, input.Stack).Return(lock, nil).Once()
+	s.env.OnActivity(activities.StateLockHolder, mock.Anything, lock).Return(&activities.LockHolder{WorkflowID: "infra-manual", Activity: "TerragruntApply"}, nil).Once()
+
+	s.env.ExecuteWorkflow(ForceUnlock, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	var appErr *temporal.ApplicationError
+	s.ErrorAs(s.env.GetWorkflowError(), &appErr)
+	s.Equal(activities.TerraformStateLockError, appErr.Type())
+	s.Contains(appErr.Error(), "lock 6a7c5a2b is held by running workflow infra-manual (TerragruntApply)")
+	s.env.AssertNotCalled(s.T(), "TerragruntForceUnlock", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *ForceUnlockWorkflowTestSuite) TestForceUnlockWorkflow_Refused() {
+	testCases := []struct {
+		name     string
+		confirm  string
+		lock     *activities.StateLock
+		errType  string
+		expected string
+	}{
+		{
+			name:     "no confirmation",
+			errType:  "ConfirmationRequired",
+			expected: `unlocking module "cluster" requires confirm to be set to the lock ID`,
+		},
+		{
+			name:     "lock taken again since it was reported",
+			confirm:  "1234",
+			lock:     s.lock(),
+			errType:  "ConfirmationRequired",
+			expected: `state of module "cluster" is locked by root@worker-7d9f with lock ID 6a7c5a2b, not 1234`,
+		},
+		{
+			name:     "not locked",
+			confirm:  "6a7c5a2b",
+			errType:  "InvalidInput",
+			expected: `state of module "cluster" in stack "production" is not locked`,
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			env := s.NewTestWorkflowEnvironment()
+			input := s.input()
+			input.Confirm = tc.confirm
+
+			env.OnActivity(activities.TerragruntLockInfo, mock.Anything, input.Url, input.Revision, input.Module, input.Stack).Return(tc.lock, nil)
+
+			env.ExecuteWorkflow(ForceUnlock, input)
+
+			s.True(env.IsWorkflowCompleted())
+			var appErr *temporal.ApplicationError
+			s.ErrorAs(env.GetWorkflowError(), &appErr)
+			s.Equal(tc.errType, appErr.Type())
+			s.Contains(appErr.Error(), tc.expected)
+			env.AssertNotCalled(s.T(), "TerragruntForceUnlock", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestForceUnlockWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(ForceUnlockWorkflowTestSuite))
+}
@@ -3,6 +3,7 @@ package workflows
 import (
 	"fmt"
 	"os"
+	"sort"
 	"time"
 
 	"cloudlab/controller/activities"
@@ -11,17 +12,170 @@ import (
 	"go.temporal.io/sdk/workflow"
 )
 
+type InfraMode string
+
+const (
+	InfraModeApply InfraMode = "apply"
+	InfraModePlan  InfraMode = "plan"
+)
+
 type InfraInputs struct {
 	Url         string
 	Revision    string
 	OldRevision string
 	Stack       string
+	// Mode defaults to apply, plan only reports what each module would change
+	Mode InfraMode
+	// Approval controls which plans have to be approved before they are applied
+	Approval ApprovalPolicy
+	// MaxParallelism limits how many modules run at once, zero means no limit
+	MaxParallelism int
+	// FailurePolicy defaults to fail-fast, continue keeps applying modules that do not depend on a failed one
+	FailurePolicy FailurePolicy
+	// Modules runs only the modules matching these paths or globs like metal/*/bootstrap instead of pruning
+	// by OldRevision, Include adds their dependents or dependencies
+	Modules []string                `json:",omitempty"`
+	Include activities.GraphInclude `json:",omitempty"`
+	// PlanStore is a directory or oci://<registry>/<repository> where plan mode saves every plan as a PlanSet,
+	// oci+http:// reaches a local registry without TLS
+	PlanStore string `json:",omitempty"`
+	// PlanSet is the digest of a saved plan set, apply mode then applies exactly those plans from PlanStore
+	PlanSet string `json:",omitempty"`
+	// StateBackup is an s3://<bucket>/<prefix> location on the state backend of the stack, as configured in its
+	// terragrunt configuration, where the state of every module is copied before it is
+	// applied or destroyed, StateBackupRetention snapshots are kept per module
+	StateBackup          string `json:",omitempty"`
+	StateBackupRetention int    `json:",omitempty"`
+}
+
+type FailurePolicy string
+
+const (
+	FailFast     FailurePolicy = "fail-fast"
+	FailContinue FailurePolicy = "continue"
+)
+
+type ModuleStatus string
+
+const (
+	ModulePlanned  ModuleStatus = "planned"
+	ModuleApplied  ModuleStatus = "applied"
+	ModuleFailed   ModuleStatus = "failed"
+	ModuleRejected ModuleStatus = "rejected"
+	// ModuleDestroyed means a module removed since OldRevision was destroyed
+	ModuleDestroyed ModuleStatus = "destroyed"
+	// ModuleSkipped means a dependency failed or was rejected
+	ModuleSkipped ModuleStatus = "skipped"
+)
+
+type ModuleOutcome struct {
+	Status ModuleStatus
+	// Error is the failure message of a failed module
+	Error string `json:",omitempty"`
+	// Dependency is the failed or rejected dependency of a skipped module
+	Dependency string `json:",omitempty"`
+}
+
+type InfraResult struct {
+	Graph *activities.Graph
+	// Plans is keyed by module path and populated in plan mode or when approval is required
+	Plans map[string]*activities.PlanSummary
+	// Applies is keyed by module path and holds the report of every applied module
+	Applies map[string]*activities.ApplyReport
+	// Outcomes is keyed by module path and records what happened to every module of the graph
+	Outcomes map[string]*ModuleOutcome
+	// Removed lists the modules deleted since OldRevision, their destroy plans are in Plans
+	Removed []string `json:",omitempty"`
+	// RemovedNotChecked says why deleted modules were not looked for, an empty Removed does not mean that nothing
+	// was deleted then
+	RemovedNotChecked string `json:",omitempty"`
+	// Outputs is keyed by module path and holds the outputs of every applied module, sensitive values are redacted
+	Outputs map[string]map[string]activities.ModuleOutput `json:",omitempty"`
+	// Artifacts is keyed by module path and holds the plans saved to PlanStore
+	Artifacts map[string]*activities.PlanArtifact `json:",omitempty"`
+	// PlanSet is the digest of the saved plans, pass it back with PlanStore to apply them
+	PlanSet string `json:",omitempty"`
+	// SupersededBy is the newer run of the stack that replaced this one while it was queued
+	SupersededBy string `json:",omitempty"`
+	// Snapshots is keyed by module path and holds the state backups taken before changing them, RestoreState rolls
+	// a module back to its snapshot
+	Snapshots map[string]*activities.StateSnapshot `json:",omitempty"`
+}
+
+type ApprovalPolicy string
+
+const (
+	// ApprovalNever applies every module without planning first
+	ApprovalNever ApprovalPolicy = "never"
+	// ApprovalOnDestroy applies plans without destroys automatically and waits for approval otherwise
+	ApprovalOnDestroy ApprovalPolicy = "destroy"
+	// ApprovalAlways waits for approval of every plan with changes
+	ApprovalAlways ApprovalPolicy = "always"
+)
+
+type ApprovalDecision string
+
+const (
+	ApprovalApprove ApprovalDecision = "approve"
+	ApprovalReject  ApprovalDecision = "reject"
+)
+
+const (
+	ApprovalSignalName = "approval"
+	PendingPlansQuery  = "pending-plans"
+	// GraphQuery renders the stack graph with pruning highlights, its argument is the format: dot, mermaid or json
+	GraphQuery = "graph"
+)
+
+// ApprovalSignal approves or rejects pending plans, all of them if Modules is empty.
+// Destroying a removed module has to be confirmed by naming it in Modules.
+type ApprovalSignal struct {
+	Decision ApprovalDecision
+	Modules  []string
 }
 
-func Infra(ctx workflow.Context, input InfraInputs) (*activities.Graph, error) {
+func Infra(ctx workflow.Context, input InfraInputs) (*InfraResult, error) {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Infra workflow started", "infra", input)
 
+	if input.Mode == "" {
+		input.Mode = InfraModeApply
+	}
+	if input.Mode != InfraModeApply && input.Mode != InfraModePlan {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("unknown infra mode %q", input.Mode), "InvalidInput", nil)
+	}
+	switch input.Approval {
+	case "", ApprovalNever, ApprovalOnDestroy, ApprovalAlways:
+	default:
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("unknown approval policy %q", input.Approval), "InvalidInput", nil)
+	}
+	switch input.FailurePolicy {
+	case "", FailFast, FailContinue:
+	default:
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("unknown failure policy %q", input.FailurePolicy), "InvalidInput", nil)
+	}
+
+	// Applies of the same stack run one at a time, plans do not change anything
+	if input.Mode == InfraModeApply && workflow.GetVersion(ctx, "stack-lock", workflow.DefaultVersion, 1) == 1 {
+		grant, release, err := acquireStackLock(ctx, input.Stack, activities.StackLockRequest{
+			Revision:     input.Revision,
+			OldRevision:  input.OldRevision,
+			Supersedable: len(input.Modules) == 0 && input.PlanSet == "",
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		if grant.SupersededBy != "" {
+			logger.Info("Infra workflow superseded by a newer run", "stack", input.Stack, "by", grant.SupersededBy)
+			return &InfraResult{SupersededBy: grant.SupersededBy}, nil
+		}
+		if grant.OldRevision != input.OldRevision {
+			logger.Info("Pruning from the base of a superseded run", "oldRevision", grant.OldRevision)
+			input.OldRevision = grant.OldRevision
+		}
+	}
+
 	cloneCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
 		StartToCloseTimeout: 1 * time.Minute,
 	})
@@ -41,61 +195,498 @@ func Infra(ctx workflow.Context, input InfraInputs) (*activities.Graph, error) {
 		},
 	})
 
-	var graph *activities.Graph
 	var prunedGraph *activities.Graph
+	var changedModules []string
+	var removedModules []string
+	var removedNotChecked string
 
 	// Get the terragrunt graph
-	if err := workflow.ExecuteActivity(analysisCtx, activities.TerragruntGraph, workspace+"/infra/"+input.Stack).Get(ctx, &graph); err != nil {
+	graph, err := stackGraph(ctx, analysisCtx, workspace+"/infra/"+input.Stack)
+	if err != nil {
 		return nil, err
 	}
 
-	// If oldRevision is not provided, use the full graph (no pruning)
-	if input.OldRevision == "" {
+	// Modules in a cycle have no valid apply order, refuse the whole graph before anything runs
+	if err := graph.DetectCycle(); err != nil {
+		logger.Error("Dependency cycle in terragrunt graph", "error", err)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), activities.DependencyCycleError, err)
+	}
+
+	var planSet *activities.PlanSet
+	if input.PlanSet != "" {
+		if planSet, err = loadPlanSet(ctx, input); err != nil {
+			return nil, err
+		}
+		selected, err := plannedGraph(graph, planSet)
+		if err != nil {
+			return nil, err
+		}
+		prunedGraph = selected
+		changedModules = selected.GetNodes()
+		logger.Info("Applying saved plans", "planSet", input.PlanSet, "nodes", len(prunedGraph.Nodes))
+		removedNotChecked = "applying saved plans, deleted modules are only destroyed from an OldRevision"
+	} else if len(input.Modules) > 0 {
+		targets, selected, err := selectModules(graph, input)
+		if err != nil {
+			return nil, err
+		}
+		prunedGraph = selected
+		// Targets are highlighted like changed modules in the graph query
+		changedModules = targets
+		logger.Info("Selected target modules", "targets", targets, "include", input.Include, "nodes", len(prunedGraph.Nodes))
+		removedNotChecked = "targeting modules, deleted modules are only destroyed from an OldRevision"
+	} else if input.OldRevision == "" {
+		// If oldRevision is not provided, use the full graph (no pruning)
 		logger.Info("No oldRevision provided, using full graph", "nodes", len(graph.Nodes))
 		prunedGraph = graph
+		removedNotChecked = "no OldRevision to compare with"
 	} else {
 		// Determine changed modules and prune graph
-		var changedModules []string
-		if err := workflow.ExecuteActivity(analysisCtx, activities.ChangedModules, workspace, input.OldRevision).Get(ctx, &changedModules); err != nil {
+		if workflow.GetVersion(ctx, "change-reasons", workflow.DefaultVersion, 1) == 1 {
+			var changes *activities.ChangeSet
+			if err := workflow.ExecuteActivity(analysisCtx, activities.DetectChanges, workspace, input.OldRevision, input.Stack).Get(ctx, &changes); err != nil {
+				return nil, err
+			}
+			changedModules = changes.Modules
+
+			if err := workflow.ExecuteActivity(analysisCtx, activities.PruneChanges, graph, changes).Get(ctx, &prunedGraph); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := workflow.ExecuteActivity(analysisCtx, activities.ChangedModules, workspace, input.OldRevision).Get(ctx, &changedModules); err != nil {
+				return nil, err
+			}
+
+			if err := workflow.ExecuteActivity(analysisCtx, activities.PruneGraph, graph, changedModules).Get(ctx, &prunedGraph); err != nil {
+				return nil, err
+			}
+		}
+
+		logger.Info("Graph pruning completed", "nodes", len(prunedGraph.Nodes))
+		for _, module := range prunedGraph.GetNodes() {
+			if reason, ok := prunedGraph.Reasons[module]; ok {
+				logger.Info("Module included", "module", module, "reason", reason)
+			}
+		}
+
+		if workflow.GetVersion(ctx, "removed-modules", workflow.DefaultVersion, 1) == 1 {
+			if err := workflow.ExecuteActivity(analysisCtx, activities.RemovedModules, workspace, input.OldRevision, input.Stack).Get(ctx, &removedModules); err != nil {
+				return nil, err
+			}
+			if len(removedModules) > 0 {
+				logger.Warn("Modules were removed since the old revision", "modules", removedModules)
+			}
+		}
+	}
+
+	if removedNotChecked != "" {
+		logger.Warn("Deleted modules were not looked for", "reason", removedNotChecked)
+	}
+
+	marks := activities.PruneMarks(graph, prunedGraph, changedModules)
+	if err := workflow.SetQueryHandler(ctx, GraphQuery, func(format activities.GraphFormat) (string, error) {
+		if format == "" {
+			format = activities.GraphFormatDot
+		}
+		return activities.RenderGraph(graph, marks, format)
+	}); err != nil {
+		return nil, err
+	}
+
+	result := &InfraResult{
+		Graph:             prunedGraph,
+		Plans:             make(map[string]*activities.PlanSummary),
+		Applies:           make(map[string]*activities.ApplyReport),
+		Outcomes:          make(map[string]*ModuleOutcome),
+		Removed:           removedModules,
+		RemovedNotChecked: removedNotChecked,
+		Outputs:           make(map[string]map[string]activities.ModuleOutput),
+	}
+	if input.PlanStore != "" && input.Mode == InfraModePlan {
+		result.Artifacts = make(map[string]*activities.PlanArtifact)
+	}
+	if input.StateBackup != "" && input.Mode == InfraModeApply {
+		result.Snapshots = make(map[string]*activities.StateSnapshot)
+	}
+
+	gated := input.Mode == InfraModeApply && input.Approval != "" && input.Approval != ApprovalNever
+	failed := 0
+
+	pending := make(map[string]*activities.PlanSummary)
+	decisions := make(map[string]ApprovalDecision)
+	removals := make(map[string]bool)
+	if err := workflow.SetQueryHandler(ctx, PendingPlansQuery, func() (map[string]*activities.PlanSummary, error) {
+		return pending, nil
+	}); err != nil {
+		return nil, err
+	}
+	approvalChannel := workflow.GetSignalChannel(ctx, ApprovalSignalName)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var signal ApprovalSignal
+			approvalChannel.Receive(ctx, &signal)
+
+			targets := signal.Modules
+			if len(targets) == 0 {
+				for module := range pending {
+					if !removals[module] {
+						targets = append(targets, module)
+					}
+				}
+				sort.Strings(targets)
+			}
+			for _, module := range targets {
+				if _, ok := pending[module]; !ok {
+					logger.Warn("Ignoring decision for a module that is not pending", "module", module, "decision", signal.Decision)
+					continue
+				}
+				switch signal.Decision {
+				case ApprovalApprove, ApprovalReject:
+					logger.Info("Plan decision received", "module", module, "decision", signal.Decision)
+					decisions[module] = signal.Decision
+					delete(pending, module)
+				default:
+					logger.Warn("Ignoring unknown approval decision", "module", module, "decision", signal.Decision)
+				}
+			}
+		}
+	})
+
+	// fail records a failed module, the error is only returned to stop the schedule when failing fast
+	fail := func(module string, activity string, err error) (bool, error) {
+		logger.Error(activity+" failed", "module", module, "error", err)
+		if input.FailurePolicy != FailContinue {
+			return false, err
+		}
+		result.Outcomes[module] = &ModuleOutcome{Status: ModuleFailed, Error: err.Error()}
+		failed++
+		return false, nil
+	}
+
+	// backupState snapshots the state of a module before it is changed, modules without state have no snapshot
+	backupState := func(ctx workflow.Context, module string, revision string) error {
+		if result.Snapshots == nil {
+			return nil
+		}
+		var snapshot *activities.StateSnapshot
+		if err := workflow.ExecuteActivity(moduleContext(ctx, input.Stack, module), activities.BackupState, input.Url, revision, module, input.Stack, input.StateBackup, input.StateBackupRetention).Get(ctx, &snapshot); err != nil {
+			return err
+		}
+		if snapshot != nil {
+			result.Snapshots[module] = snapshot
+			logger.Info("Module state backed up", "module", module, "key", snapshot.Key, "serial", snapshot.Serial)
+		}
+		return nil
+	}
+
+	runModule := func(ctx workflow.Context, module string) (bool, error) {
+		moduleCtx := moduleContext(ctx, input.Stack, module)
+
+		if planSet != nil {
+			if err := backupState(ctx, module, input.Revision); err != nil {
+				return fail(module, "BackupState", err)
+			}
+			var report *activities.ApplyReport
+			if err := workflow.ExecuteActivity(moduleCtx, activities.TerragruntApplyPlan, input.Url, input.Revision, input.Stack, input.PlanStore, planSet.Plans[module]).Get(ctx, &report); err != nil {
+				return fail(module, "TerragruntApplyPlan", err)
+			}
+			result.Applies[module] = report
+			if len(report.Outputs) > 0 {
+				result.Outputs[module] = report.Outputs
+			}
+			result.Outcomes[module] = &ModuleOutcome{Status: ModuleApplied}
+			logger.Info("Saved plan applied", "module", module, "add", report.Add, "change", report.Change, "destroy", report.Destroy)
+			return true, nil
+		}
+
+		if result.Artifacts != nil {
+			var artifact *activities.PlanArtifact
+			if err := workflow.ExecuteActivity(moduleCtx, activities.TerragruntSavePlan, input.Url, input.Revision, module, input.Stack, input.PlanStore).Get(ctx, &artifact); err != nil {
+				return fail(module, "TerragruntSavePlan", err)
+			}
+			result.Artifacts[module] = artifact
+			result.Plans[module] = artifact.Summary
+			result.Outcomes[module] = &ModuleOutcome{Status: ModulePlanned}
+			logger.Info("Module plan saved", "module", module, "digest", artifact.Digest, "add", artifact.Summary.Add, "change", artifact.Summary.Change, "destroy", artifact.Summary.Destroy)
+			return true, nil
+		}
+
+		if input.Mode == InfraModePlan || gated {
+			var plan *activities.PlanSummary
+			if err := workflow.ExecuteActivity(moduleCtx, activities.TerragruntPlan, input.Url, input.Revision, module, input.Stack).Get(ctx, &plan); err != nil {
+				return fail(module, "TerragruntPlan", err)
+			}
+			result.Plans[module] = plan
+			logger.Info("Module plan completed", "module", module, "add", plan.Add, "change", plan.Change, "destroy", plan.Destroy)
+
+			if input.Mode == InfraModePlan {
+				result.Outcomes[module] = &ModuleOutcome{Status: ModulePlanned}
+				return true, nil
+			}
+
+			if needsApproval(input.Approval, plan) {
+				logger.Info("Waiting for plan approval", "module", module)
+				pending[module] = plan
+				if err := workflow.Await(ctx, func() bool {
+					_, decided := decisions[module]
+					return decided
+				}); err != nil {
+					return false, err
+				}
+				if decisions[module] == ApprovalReject {
+					result.Outcomes[module] = &ModuleOutcome{Status: ModuleRejected}
+					return false, nil
+				}
+			}
+		}
+
+		if err := backupState(ctx, module, input.Revision); err != nil {
+			return fail(module, "BackupState", err)
+		}
+		var report *activities.ApplyReport
+		if err := workflow.ExecuteActivity(moduleCtx, activities.TerragruntApply, input.Url, input.Revision, module, input.Stack).Get(ctx, &report); err != nil {
+			return fail(module, "TerragruntApply", err)
+		}
+		result.Applies[module] = report
+		if len(report.Outputs) > 0 {
+			result.Outputs[module] = report.Outputs
+		}
+		result.Outcomes[module] = &ModuleOutcome{Status: ModuleApplied}
+		logger.Info("Module apply completed", "module", module, "add", report.Add, "change", report.Change, "destroy", report.Destroy)
+		return true, nil
+	}
+
+	skipModule := func(module string, dependency string) {
+		logger.Warn("Skipping module because a dependency was not applied", "module", module, "dependency", dependency)
+		result.Outcomes[module] = &ModuleOutcome{Status: ModuleSkipped, Dependency: dependency}
+	}
+
+	logger.Info("Starting terragrunt "+string(input.Mode), "modules", len(prunedGraph.Nodes), "maxParallelism", input.MaxParallelism)
+	if err := scheduleGraph(ctx, prunedGraph, input.MaxParallelism, runModule, skipModule); err != nil {
+		return nil, err
+	}
+
+	if len(removedModules) > 0 {
+		removeModule := func(ctx workflow.Context, module string) (bool, error) {
+			plan, ok := result.Plans[module]
+			if !ok {
+				return false, nil
+			}
+			if input.Mode == InfraModePlan {
+				result.Outcomes[module] = &ModuleOutcome{Status: ModulePlanned}
+				return true, nil
+			}
+
+			logger.Info("Waiting for confirmation to destroy removed module", "module", module, "destroy", plan.Destroy)
+			removals[module] = true
+			pending[module] = plan
+			if err := workflow.Await(ctx, func() bool {
+				_, decided := decisions[module]
+				return decided
+			}); err != nil {
+				return false, err
+			}
+			if decisions[module] == ApprovalReject {
+				result.Outcomes[module] = &ModuleOutcome{Status: ModuleRejected}
+				return false, nil
+			}
+
+			if err := backupState(ctx, module, input.OldRevision); err != nil {
+				return fail(module, "BackupState", err)
+			}
+			if err := workflow.ExecuteActivity(moduleContext(ctx, input.Stack, module), activities.TerragruntDestroy, input.Url, input.OldRevision, module, input.Stack).Get(ctx, nil); err != nil {
+				return fail(module, "TerragruntDestroy", err)
+			}
+			result.Outcomes[module] = &ModuleOutcome{Status: ModuleDestroyed}
+			logger.Info("Removed module destroyed", "module", module)
+			return true, nil
+		}
+
+		keepModule := func(module string, dependent string) {
+			logger.Warn("Keeping removed module because a dependent was not destroyed", "module", module, "dependent", dependent)
+			result.Outcomes[module] = &ModuleOutcome{Status: ModuleSkipped, Dependency: dependent}
+		}
+
+		if err := destroyRemovedModules(ctx, input, removedModules, result, fail, removeModule, keepModule); err != nil {
 			return nil, err
 		}
+	}
 
-		if err := workflow.ExecuteActivity(analysisCtx, activities.PruneGraph, graph, changedModules).Get(ctx, &prunedGraph); err != nil {
+	// A partial plan set would apply as if the failed modules had nothing to change
+	if result.Artifacts != nil && failed == 0 {
+		storeCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: 1 * time.Minute,
+		})
+		set := &activities.PlanSet{Stack: input.Stack, Plans: result.Artifacts}
+		if err := workflow.ExecuteActivity(storeCtx, activities.SavePlanSet, input.PlanStore, set).Get(ctx, &result.PlanSet); err != nil {
 			return nil, err
 		}
+		logger.Info("Plan set saved", "planSet", result.PlanSet, "modules", len(result.Artifacts))
+	}
 
-		logger.Info("Graph pruning completed", "nodes", len(prunedGraph.Nodes))
+	if failed > 0 {
+		logger.Error("Infra workflow completed with failures", "mode", input.Mode, "failed", failed, "modules", len(prunedGraph.Nodes))
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("%d of %d modules failed", failed, len(prunedGraph.Nodes)),
+			"InfraModulesFailed",
+			nil,
+			result,
+		)
 	}
 
-	for levelIndex, level := range prunedGraph.TopologicalSort() {
-		logger.Info("Starting terragrunt apply", "level", levelIndex, "modules", level)
-
-		var futures []workflow.Future
-		for _, module := range level {
-			moduleCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
-				StartToCloseTimeout: 30 * time.Minute,
-				HeartbeatTimeout:    2 * time.Minute,
-				Summary:             fmt.Sprintf("%s/%s", input.Stack, module),
-				RetryPolicy: &temporal.RetryPolicy{
-					MaximumAttempts: 2,
-					NonRetryableErrorTypes: []string{
-						"TerraformValidationError",
-						"TerraformPlanError",
-					},
-				},
-			})
-			futures = append(futures, workflow.ExecuteActivity(moduleCtx, activities.TerragruntApply, input.Url, input.Revision, module, input.Stack))
-		}
-
-		for i, future := range futures {
-			if err := future.Get(ctx, nil); err != nil {
-				logger.Error("TerragruntApply failed", "module", level[i], "level", levelIndex, "error", err)
-				return nil, err
+	logger.Info("Infra workflow completed", "mode", input.Mode, "modules", len(prunedGraph.Nodes))
+	return result, nil
+}
+
+// destroyRemovedModules plans the destruction of removed modules from a checkout of the old revision,
+// then destroys them in reverse dependency order of the old graph
+func destroyRemovedModules(ctx workflow.Context, input InfraInputs, removedModules []string, result *InfraResult, fail func(string, string, error) (bool, error), run nodeRunner, skip nodeSkipper) error {
+	cloneCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 1 * time.Minute,
+	})
+	var oldWorkspace string
+	if err := workflow.ExecuteActivity(cloneCtx, activities.Clone, input.Url, input.OldRevision).Get(ctx, &oldWorkspace); err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(oldWorkspace)
+
+	analysisCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 1,
+		},
+	})
+	oldGraph, err := stackGraph(ctx, analysisCtx, oldWorkspace+"/infra/"+input.Stack)
+	if err != nil {
+		return err
+	}
+
+	var futures []workflow.Future
+	for _, module := range removedModules {
+		futures = append(futures, workflow.ExecuteActivity(moduleContext(ctx, input.Stack, module), activities.TerragruntPlanDestroy, input.Url, input.OldRevision, module, input.Stack))
+	}
+	for i, future := range futures {
+		var plan *activities.PlanSummary
+		if err := future.Get(ctx, &plan); err != nil {
+			if _, err := fail(removedModules[i], "TerragruntPlanDestroy", err); err != nil {
+				return err
 			}
-			logger.Info("Module apply completed", "module", level[i], "level", levelIndex)
+			continue
 		}
+		result.Plans[removedModules[i]] = plan
+	}
+
+	// Dependents have to be destroyed before the modules they depend on
+	removalGraph := oldGraph.Subgraph(removedModules)
+	for _, module := range removedModules {
+		removalGraph.AddNode(module)
+	}
+	return scheduleGraph(ctx, removalGraph.Reverse(), input.MaxParallelism, run, skip)
+}
+
+// selectModules resolves the module patterns of a targeted run and selects them from the graph, targets replace
+// pruning so they cannot be combined with an OldRevision
+func selectModules(graph *activities.Graph, input InfraInputs) ([]string, *activities.Graph, error) {
+	if input.OldRevision != "" {
+		return nil, nil, temporal.NewNonRetryableApplicationError("modules and oldRevision cannot be combined", "InvalidInput", nil)
+	}
+
+	targets, err := graph.Match(input.Modules)
+	if err != nil {
+		return nil, nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("%s in stack %q", err, input.Stack), "InvalidInput", err)
+	}
+
+	selected, err := graph.Select(targets, input.Include)
+	if err != nil {
+		return nil, nil, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidInput", err)
+	}
+	return targets, selected, nil
+}
+
+// loadPlanSet validates the inputs of a saved plan apply and loads the plan set, saved plans replace planning,
+// approval and pruning so none of them can be combined with it
+func loadPlanSet(ctx workflow.Context, input InfraInputs) (*activities.PlanSet, error) {
+	switch {
+	case input.PlanStore == "":
+		return nil, temporal.NewNonRetryableApplicationError("planSet requires a planStore", "InvalidInput", nil)
+	case input.Mode != InfraModeApply:
+		return nil, temporal.NewNonRetryableApplicationError("planSet can only be applied", "InvalidInput", nil)
+	case input.OldRevision != "" || len(input.Modules) > 0:
+		return nil, temporal.NewNonRetryableApplicationError("planSet cannot be combined with oldRevision or modules", "InvalidInput", nil)
 	}
 
-	logger.Info("Infra workflow completed", "levels", len(prunedGraph.TopologicalSort()), "modules", len(prunedGraph.Nodes))
-	return prunedGraph, nil
+	storeCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 1 * time.Minute,
+	})
+	var set *activities.PlanSet
+	if err := workflow.ExecuteActivity(storeCtx, activities.LoadPlanSet, input.PlanStore, input.PlanSet).Get(ctx, &set); err != nil {
+		return nil, err
+	}
+	if set.Stack != input.Stack {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("plan set %s is for stack %q, not %q", input.PlanSet, set.Stack, input.Stack), "InvalidInput", nil)
+	}
+	return set, nil
+}
+
+// plannedGraph selects the modules of a plan set from the graph, a planned module that no longer exists means
+// the plan set is for a different revision
+func plannedGraph(graph *activities.Graph, set *activities.PlanSet) (*activities.Graph, error) {
+	modules := make([]string, 0, len(set.Plans))
+	for module := range set.Plans {
+		if !graph.Nodes[module] {
+			return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("planned module %q is not in the graph", module), activities.PlanStaleError, nil)
+		}
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	return graph.Subgraph(modules), nil
+}
+
+// stackGraph reads the dependency graph of a stack checkout without the terragrunt binary, workflows started
+// before HCLGraph existed keep running terragrunt dag graph so that their histories replay
+func stackGraph(ctx workflow.Context, analysisCtx workflow.Context, stackPath string) (*activities.Graph, error) {
+	graphActivity := activities.HCLGraph
+	if workflow.GetVersion(ctx, "native-graph", workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		graphActivity = activities.TerragruntGraph
+	}
+
+	var graph *activities.Graph
+	if err := workflow.ExecuteActivity(analysisCtx, graphActivity, stackPath).Get(ctx, &graph); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// moduleTimeout leaves a terragrunt command 30 minutes on top of the longest wait for the state lock of its module
+const moduleTimeout = 30*time.Minute + activities.StateLockWait
+
+func moduleContext(ctx workflow.Context, stack string, module string) workflow.Context {
+	return workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: moduleTimeout,
+		HeartbeatTimeout:    2 * time.Minute,
+		Summary:             fmt.Sprintf("%s/%s", stack, module),
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 2,
+			NonRetryableErrorTypes: []string{
+				activities.TerraformValidationError,
+				activities.TerraformPlanError,
+				activities.TerraformProviderAuthError,
+				activities.PlanStaleError,
+				activities.StateSnapshotError,
+			},
+		},
+	})
+}
+
+// needsApproval reports whether a plan has to wait for an approval signal before it is applied
+func needsApproval(policy ApprovalPolicy, plan *activities.PlanSummary) bool {
+	switch policy {
+	case ApprovalAlways:
+		return plan.HasChanges()
+	case ApprovalOnDestroy:
+		return plan.Destroy > 0
+	default:
+		return false
+	}
 }
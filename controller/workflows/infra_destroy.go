@@ -0,0 +1,131 @@
+package workflows
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+type InfraDestroyInputs struct {
+	Url      string
+	Revision string
+	Stack    string
+	// Modules limits the teardown to these module paths, the whole stack is destroyed if empty
+	Modules []string
+	// IncludeDependents also destroys every module that depends on one of Modules
+	IncludeDependents bool
+	// Confirm must be set to the stack name to destroy anything other than the local stack
+	Confirm string
+}
+
+// InfraDestroy tears down modules of a stack in reverse dependency order, holding the lock of the stack
+func InfraDestroy(ctx workflow.Context, input InfraDestroyInputs) (*activities.Graph, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("InfraDestroy workflow started", "infra", input)
+
+	if input.Stack != "local" && input.Confirm != input.Stack {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("destroying stack %q requires confirm to be set to the stack name", input.Stack),
+			"ConfirmationRequired",
+			nil,
+		)
+	}
+
+	// A teardown must not run while an apply or a restore of the same stack does
+	if workflow.GetVersion(ctx, "stack-lock", workflow.DefaultVersion, 1) == 1 {
+		_, release, err := acquireStackLock(ctx, input.Stack, activities.StackLockRequest{Revision: input.Revision})
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	cloneCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 1 * time.Minute,
+	})
+
+	var workspace string
+	if err := workflow.ExecuteActivity(cloneCtx, activities.Clone, input.Url, input.Revision).Get(ctx, &workspace); err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(workspace)
+
+	analysisCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 1,
+		},
+	})
+
+	graph, err := stackGraph(ctx, analysisCtx, workspace+"/infra/"+input.Stack)
+	if err != nil {
+		return nil, err
+	}
+
+	var targetGraph *activities.Graph
+	if len(input.Modules) == 0 {
+		targetGraph = graph
+	} else {
+		for _, module := range input.Modules {
+			if !graph.Nodes[module] {
+				return nil, temporal.NewNonRetryableApplicationError(
+					fmt.Sprintf("module %q not found in stack %q", module, input.Stack),
+					"InvalidInput",
+					nil,
+				)
+			}
+		}
+
+		if input.IncludeDependents {
+			if err := workflow.ExecuteActivity(analysisCtx, activities.PruneGraph, graph, input.Modules).Get(ctx, &targetGraph); err != nil {
+				return nil, err
+			}
+		} else {
+			targetGraph = graph.Subgraph(input.Modules)
+		}
+	}
+
+	levels, err := targetGraph.TopologicalSort()
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), activities.DependencyCycleError, err)
+	}
+	for levelIndex := len(levels) - 1; levelIndex >= 0; levelIndex-- {
+		level := levels[levelIndex]
+		logger.Info("Starting terragrunt destroy", "level", levelIndex, "modules", level)
+
+		var futures []workflow.Future
+		for _, module := range level {
+			moduleCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+				StartToCloseTimeout: moduleTimeout,
+				HeartbeatTimeout:    2 * time.Minute,
+				Summary:             fmt.Sprintf("destroy %s/%s", input.Stack, module),
+				RetryPolicy: &temporal.RetryPolicy{
+					MaximumAttempts: 2,
+					NonRetryableErrorTypes: []string{
+						activities.TerraformValidationError,
+						activities.TerraformPlanError,
+						activities.TerraformProviderAuthError,
+					},
+				},
+			})
+			futures = append(futures, workflow.ExecuteActivity(moduleCtx, activities.TerragruntDestroy, input.Url, input.Revision, module, input.Stack))
+		}
+
+		for i, future := range futures {
+			if err := future.Get(ctx, nil); err != nil {
+				logger.Error("TerragruntDestroy failed", "module", level[i], "level", levelIndex, "error", err)
+				return nil, err
+			}
+			logger.Info("Module destroy completed", "module", level[i], "level", levelIndex)
+		}
+	}
+
+	logger.Info("InfraDestroy workflow completed", "levels", len(levels), "modules", len(targetGraph.Nodes))
+	return targetGraph, nil
+}
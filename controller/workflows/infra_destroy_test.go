@@ -0,0 +1,223 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/testsuite"
+)
+
+type InfraDestroyWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+}
+
+func (s *InfraDestroyWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.SetTestTimeout(30 * time.Second)
+}
+
+func (s *InfraDestroyWorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+// vpc <- database <- app, vpc <- cache
+func (s *InfraDestroyWorkflowTestSuite) graph() *activities.Graph {
+	return &activities.Graph{
+		Nodes: map[string]bool{
+			"vpc":      true,
+			"database": true,
+			"cache":    true,
+			"app":      true,
+		},
+		Edges: map[string][]string{
+			"database": {"vpc"},
+			"cache":    {"vpc"},
+			"app":      {"database"},
+		},
+	}
+}
+
+func (s *InfraDestroyWorkflowTestSuite) TestInfraDestroy_ReverseOrder() {
+	input := InfraDestroyInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "local",
+	}
+	repoPath := "/tmp/infra-12345"
+	grantStackLock(s.env)
+
+	var destroyed []string
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(s.graph(), nil)
+	for _, module := range []string{"vpc", "database", "cache", "app"} {
+		s.env.OnActivity(activities.TerragruntDestroy, mock.Anything, input.Url, input.Revision, module, input.Stack).
+			Return(nil).Run(func(mock.Arguments) { destroyed = append(destroyed, module) })
+	}
+
+	s.env.ExecuteWorkflow(InfraDestroy, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	s.Len(destroyed, 4)
+	s.Equal("app", destroyed[0])
+	s.Equal("vpc", destroyed[3])
+}
+
+func (s *InfraDestroyWorkflowTestSuite) TestInfraDestroy_ModulesWithDependents() {
+	input := InfraDestroyInputs{
+		Url:               "https://github.com/example/repo.git",
+		Revision:          "main",
+		Stack:             "local",
+		Modules:           []string{"database"},
+		IncludeDependents: true,
+	}
+	repoPath := "/tmp/infra-12345"
+	grantStackLock(s.env)
+	graph := s.graph()
+	prunedGraph := &activities.Graph{
+		Nodes: map[string]bool{"database": true, "app": true},
+		Edges: map[string][]string{"app": {"database"}},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.PruneGraph, mock.Anything, graph, input.Modules).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.TerragruntDestroy, mock.Anything, input.Url, input.Revision, "app", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntDestroy, mock.Anything, input.Url, input.Revision, "database", input.Stack).Return(nil)
+
+	s.env.ExecuteWorkflow(InfraDestroy, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result *activities.Graph
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.ElementsMatch([]string{"app", "database"}, result.GetNodes())
+}
+
+func (s *InfraDestroyWorkflowTestSuite) TestInfraDestroy_ModulesOnly() {
+	input := InfraDestroyInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "local",
+		Modules:  []string{"cache"},
+	}
+	repoPath := "/tmp/infra-12345"
+	grantStackLock(s.env)
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(s.graph(), nil)
+	s.env.OnActivity(activities.TerragruntDestroy, mock.Anything, input.Url, input.Revision, "cache", input.Stack).Return(nil)
+
+	s.env.ExecuteWorkflow(InfraDestroy, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *InfraDestroyWorkflowTestSuite) TestInfraDestroy_UnknownModule() {
+	input := InfraDestroyInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "local",
+		Modules:  []string{"missing"},
+	}
+	repoPath := "/tmp/infra-12345"
+	grantStackLock(s.env)
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(s.graph(), nil)
+
+	s.env.ExecuteWorkflow(InfraDestroy, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "not found")
+}
+
+func (s *InfraDestroyWorkflowTestSuite) TestInfraDestroy_RequiresConfirmation() {
+	input := InfraDestroyInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "production",
+	}
+
+	// Nothing should be cloned or destroyed without confirmation
+
+	s.env.ExecuteWorkflow(InfraDestroy, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "requires confirm")
+}
+
+func (s *InfraDestroyWorkflowTestSuite) TestInfraDestroy_ConfirmedNonLocalStack() {
+	input := InfraDestroyInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "production",
+		Modules:  []string{"cache"},
+		Confirm:  "production",
+	}
+	repoPath := "/tmp/infra-12345"
+	grantStackLock(s.env)
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(s.graph(), nil)
+	s.env.OnActivity(activities.TerragruntDestroy, mock.Anything, input.Url, input.Revision, "cache", input.Stack).Return(
+		errors.New("terragrunt destroy failed"))
+
+	s.env.ExecuteWorkflow(InfraDestroy, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "terragrunt destroy failed")
+}
+
+func (s *InfraDestroyWorkflowTestSuite) TestInfraDestroy_HoldsStackLock() {
+	input := InfraDestroyInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "local",
+		Modules:  []string{"cache"},
+	}
+	repoPath := "/tmp/infra-12345"
+
+	var calls []string
+	var request activities.StackLockRequest
+	s.env.OnActivity(activities.RequestStackLock, mock.Anything, input.Stack, mock.Anything).Return(func(ctx context.Context, stack string, r activities.StackLockRequest) error {
+		calls = append(calls, "lock")
+		request = r
+		s.env.SignalWorkflow(StackLockGrantSignal, StackLockGrant{})
+		return nil
+	}).Once()
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).
+		Run(func(args mock.Arguments) { calls = append(calls, "clone") }).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(s.graph(), nil)
+	s.env.OnActivity(activities.TerragruntDestroy, mock.Anything, input.Url, input.Revision, "cache", input.Stack).
+		Run(func(args mock.Arguments) { calls = append(calls, "destroy") }).Return(nil)
+	s.env.OnSignalExternalWorkflow(mock.Anything, activities.StackLockWorkflowID(input.Stack), "", StackLockReleaseSignal, mock.Anything).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(InfraDestroy, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"lock", "clone", "destroy"}, calls)
+	// A teardown is never replaced by a newer run
+	s.False(request.Supersedable)
+}
+
+func TestInfraDestroyWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(InfraDestroyWorkflowTestSuite))
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
 )
 
@@ -24,6 +25,7 @@ func (s *InfraWorkflowTestSuite) SetupTest() {
 	s.env = s.NewTestWorkflowEnvironment()
 	// Set a reasonable timeout for tests
 	s.env.SetTestTimeout(30 * time.Second)
+	grantStackLock(s.env)
 }
 
 func (s *InfraWorkflowTestSuite) AfterTest(suiteName, testName string) {
@@ -53,11 +55,12 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_Success() {
 	prunedGraph := graph // Both modules changed
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
-	s.env.OnActivity(activities.ChangedModules, mock.Anything, repoPath, input.OldRevision).Return(changedModules, nil)
-	s.env.OnActivity(activities.PruneGraph, mock.Anything, graph, changedModules).Return(prunedGraph, nil)
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module2", input.Stack).Return(nil)
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: changedModules}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: changedModules}).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module2", input.Stack).Return(&activities.ApplyReport{}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(&activities.ApplyReport{}, nil)
 
 	s.env.ExecuteWorkflow(Infra, input)
 
@@ -65,6 +68,414 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_Success() {
 	s.NoError(s.env.GetWorkflowError())
 }
 
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_Outputs() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+	}
+	repoPath := "/tmp/infra-12345"
+	graph := activities.NewGraph()
+	graph.AddEdge("bootstrap", "cluster")
+
+	clusterOutputs := map[string]activities.ModuleOutput{
+		"name":        {Value: "local"},
+		"credentials": {Sensitive: true},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "cluster", input.Stack).Return(&activities.ApplyReport{Module: "cluster", Outputs: clusterOutputs}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "bootstrap", input.Stack).Return(&activities.ApplyReport{Module: "bootstrap"}, nil)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	// Modules without outputs are left out
+	s.Equal(map[string]map[string]activities.ModuleOutput{"cluster": clusterOutputs}, result.Outputs)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_TargetedModules() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "production",
+		Modules:  []string{"metal/*/cluster"},
+		Include:  activities.IncludeDependents,
+	}
+	repoPath := "/tmp/infra-12345"
+	graph := activities.NewGraph()
+	graph.AddEdge("metal/vn-south-1/bootstrap", "metal/vn-south-1/cluster")
+	graph.AddEdge("metal/vn-southwest-1/bootstrap", "metal/vn-southwest-1/cluster")
+	graph.AddNode("oracle/legacy")
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	for _, module := range []string{"metal/vn-south-1/cluster", "metal/vn-south-1/bootstrap", "metal/vn-southwest-1/cluster", "metal/vn-southwest-1/bootstrap"} {
+		s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, module, input.Stack).Return(&activities.ApplyReport{Module: module}, nil).Once()
+	}
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertNotCalled(s.T(), "TerragruntApply", mock.Anything, input.Url, input.Revision, "oracle/legacy", input.Stack)
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Len(result.Outcomes, 4)
+	s.NotContains(result.Outcomes, "oracle/legacy")
+	s.Empty(result.Removed)
+	s.Contains(result.RemovedNotChecked, "targeting modules")
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_TargetedModulesInvalid() {
+	testCases := []struct {
+		name     string
+		input    InfraInputs
+		expected string
+	}{
+		{
+			name:     "no match",
+			input:    InfraInputs{Modules: []string{"metal/*/cluster"}},
+			expected: `module pattern "metal/*/cluster" matches no module in stack "dev"`,
+		},
+		{
+			name:     "combined with oldRevision",
+			input:    InfraInputs{Modules: []string{"module1"}, OldRevision: "HEAD~1"},
+			expected: "modules and oldRevision cannot be combined",
+		},
+		{
+			name:     "unknown include",
+			input:    InfraInputs{Modules: []string{"module1"}, Include: "everything"},
+			expected: `unknown include "everything"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			env := s.NewTestWorkflowEnvironment()
+			grantStackLock(env)
+			input := tc.input
+			input.Url = "https://github.com/example/repo.git"
+			input.Revision = "main"
+			input.Stack = "dev"
+			repoPath := "/tmp/infra-12345"
+			graph := activities.NewGraph()
+			graph.AddNode("module1")
+
+			env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+			env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+
+			env.ExecuteWorkflow(Infra, input)
+
+			s.True(env.IsWorkflowCompleted())
+			var appErr *temporal.ApplicationError
+			s.ErrorAs(env.GetWorkflowError(), &appErr)
+			s.Equal("InvalidInput", appErr.Type())
+			s.Contains(appErr.Error(), tc.expected)
+			env.AssertNotCalled(s.T(), "TerragruntApply", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_SavePlans() {
+	input := InfraInputs{
+		Url:       "https://github.com/example/repo.git",
+		Revision:  "main",
+		Stack:     "production",
+		Mode:      InfraModePlan,
+		PlanStore: "oci://registry.local/plans",
+	}
+	repoPath := "/tmp/infra-12345"
+	graph := activities.NewGraph()
+	graph.AddEdge("app", "vpc")
+
+	artifacts := map[string]*activities.PlanArtifact{
+		"vpc": {Module: "vpc", Digest: "sha256:aaaa", Revision: "abc123", Serial: 3, Summary: &activities.PlanSummary{Module: "vpc", Add: 1}},
+		"app": {Module: "app", Digest: "sha256:bbbb", Revision: "abc123", Serial: 5, Summary: &activities.PlanSummary{Module: "app", Change: 1}},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	for module, artifact := range artifacts {
+		s.env.OnActivity(activities.TerragruntSavePlan, mock.Anything, input.Url, input.Revision, module, input.Stack, input.PlanStore).Return(artifact, nil).Once()
+	}
+	s.env.OnActivity(activities.SavePlanSet, mock.Anything, input.PlanStore, &activities.PlanSet{Stack: input.Stack, Plans: artifacts}).Return("sha256:cccc", nil).Once()
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertNotCalled(s.T(), "TerragruntPlan", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal("sha256:cccc", result.PlanSet)
+	s.Equal(artifacts, result.Artifacts)
+	s.Equal(artifacts["vpc"].Summary, result.Plans["vpc"])
+	s.Equal(ModulePlanned, result.Outcomes["app"].Status)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ApplySavedPlans() {
+	input := InfraInputs{
+		Url:       "https://github.com/example/repo.git",
+		Revision:  "abc123",
+		Stack:     "production",
+		Approval:  ApprovalAlways,
+		PlanStore: "oci://registry.local/plans",
+		PlanSet:   "sha256:cccc",
+	}
+	repoPath := "/tmp/infra-12345"
+	graph := activities.NewGraph()
+	graph.AddEdge("app", "vpc")
+	graph.AddNode("dns")
+
+	set := &activities.PlanSet{
+		Stack: input.Stack,
+		Plans: map[string]*activities.PlanArtifact{
+			"vpc": {Module: "vpc", Digest: "sha256:aaaa", Revision: "abc123", Summary: &activities.PlanSummary{Module: "vpc", Destroy: 1}},
+			"app": {Module: "app", Digest: "sha256:bbbb", Revision: "abc123", Summary: &activities.PlanSummary{Module: "app", Change: 1}},
+		},
+	}
+
+	var order []string
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.LoadPlanSet, mock.Anything, input.PlanStore, input.PlanSet).Return(set, nil).Once()
+	for module, artifact := range set.Plans {
+		s.env.OnActivity(activities.TerragruntApplyPlan, mock.Anything, input.Url, input.Revision, input.Stack, input.PlanStore, artifact).
+			Run(func(args mock.Arguments) { order = append(order, module) }).
+			Return(&activities.ApplyReport{Module: module}, nil).Once()
+	}
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	// The plans were reviewed when they were saved, applying them waits for no approval
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"vpc", "app"}, order)
+	s.env.AssertNotCalled(s.T(), "TerragruntPlan", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	s.env.AssertNotCalled(s.T(), "TerragruntApply", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Len(result.Outcomes, 2)
+	s.NotContains(result.Outcomes, "dns")
+	s.Equal(ModuleApplied, result.Outcomes["vpc"].Status)
+	s.Contains(result.RemovedNotChecked, "applying saved plans")
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ApplySavedPlansInvalid() {
+	testCases := []struct {
+		name     string
+		input    InfraInputs
+		set      *activities.PlanSet
+		errType  string
+		expected string
+	}{
+		{
+			name:     "no plan store",
+			input:    InfraInputs{PlanSet: "sha256:cccc"},
+			errType:  "InvalidInput",
+			expected: "planSet requires a planStore",
+		},
+		{
+			name:     "plan mode",
+			input:    InfraInputs{PlanSet: "sha256:cccc", PlanStore: "/plans", Mode: InfraModePlan},
+			errType:  "InvalidInput",
+			expected: "planSet can only be applied",
+		},
+		{
+			name:     "combined with modules",
+			input:    InfraInputs{PlanSet: "sha256:cccc", PlanStore: "/plans", Modules: []string{"module1"}},
+			errType:  "InvalidInput",
+			expected: "planSet cannot be combined with oldRevision or modules",
+		},
+		{
+			name:     "other stack",
+			input:    InfraInputs{PlanSet: "sha256:cccc", PlanStore: "/plans"},
+			set:      &activities.PlanSet{Stack: "production"},
+			errType:  "InvalidInput",
+			expected: `plan set sha256:cccc is for stack "production", not "dev"`,
+		},
+		{
+			name:  "module no longer exists",
+			input: InfraInputs{PlanSet: "sha256:cccc", PlanStore: "/plans"},
+			set: &activities.PlanSet{Stack: "dev", Plans: map[string]*activities.PlanArtifact{
+				"module2": {Module: "module2"},
+			}},
+			errType:  activities.PlanStaleError,
+			expected: `planned module "module2" is not in the graph`,
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			env := s.NewTestWorkflowEnvironment()
+			grantStackLock(env)
+			input := tc.input
+			input.Url = "https://github.com/example/repo.git"
+			input.Revision = "main"
+			input.Stack = "dev"
+			repoPath := "/tmp/infra-12345"
+			graph := activities.NewGraph()
+			graph.AddNode("module1")
+
+			env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+			env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+			if tc.set != nil {
+				env.OnActivity(activities.LoadPlanSet, mock.Anything, input.PlanStore, input.PlanSet).Return(tc.set, nil)
+			}
+
+			env.ExecuteWorkflow(Infra, input)
+
+			s.True(env.IsWorkflowCompleted())
+			var appErr *temporal.ApplicationError
+			s.ErrorAs(env.GetWorkflowError(), &appErr)
+			s.Equal(tc.errType, appErr.Type())
+			s.Contains(appErr.Error(), tc.expected)
+			env.AssertNotCalled(s.T(), "TerragruntApplyPlan", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_StackLock() {
+	testCases := []struct {
+		name  string
+		grant StackLockGrant
+	}{
+		{
+			name:  "superseded while queued",
+			grant: StackLockGrant{SupersededBy: "infra-newer"},
+		},
+		{
+			name:  "granted with the base of a superseded run",
+			grant: StackLockGrant{OldRevision: "HEAD~3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			env := s.NewTestWorkflowEnvironment()
+			input := InfraInputs{
+				Url:         "https://github.com/example/repo.git",
+				Revision:    "main",
+				OldRevision: "HEAD~1",
+				Stack:       "dev",
+			}
+			repoPath := "/tmp/infra-12345"
+			graph := activities.NewGraph()
+			graph.AddNode("module1")
+
+			var request activities.StackLockRequest
+			env.OnActivity(activities.RequestStackLock, mock.Anything, input.Stack, mock.Anything).Return(func(ctx context.Context, stack string, r activities.StackLockRequest) error {
+				request = r
+				env.SignalWorkflow(StackLockGrantSignal, tc.grant)
+				return nil
+			}).Once()
+			env.OnSignalExternalWorkflow(mock.Anything, activities.StackLockWorkflowID(input.Stack), "", StackLockReleaseSignal, mock.Anything).Return(nil).Once()
+			if tc.grant.SupersededBy == "" {
+				env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+				env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+				env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, "HEAD~3", input.Stack).Return(&activities.ChangeSet{Modules: []string{"module1"}}, nil).Once()
+				env.OnActivity(activities.PruneChanges, mock.Anything, graph, mock.Anything).Return(graph, nil)
+				env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, "HEAD~3", input.Stack).Return(nil, nil).Once()
+				env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(&activities.ApplyReport{}, nil).Once()
+			}
+
+			env.ExecuteWorkflow(Infra, input)
+
+			s.True(env.IsWorkflowCompleted())
+			s.NoError(env.GetWorkflowError())
+			env.AssertExpectations(s.T())
+			s.Equal("HEAD~1", request.OldRevision)
+			s.True(request.Supersedable)
+
+			var result *InfraResult
+			s.NoError(env.GetWorkflowResult(&result))
+			s.Equal(tc.grant.SupersededBy, result.SupersededBy)
+			if tc.grant.SupersededBy != "" {
+				env.AssertNotCalled(s.T(), "Clone", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_StateBackup() {
+	input := InfraInputs{
+		Url:                  "https://github.com/example/repo.git",
+		Revision:             "main",
+		OldRevision:          "HEAD~1",
+		Stack:                "dev",
+		StateBackup:          "s3://tfstate-backups/dev",
+		StateBackupRetention: 10,
+	}
+	repoPath := "/tmp/infra-12345"
+	graph := activities.NewGraph()
+	graph.AddEdge("app", "vpc")
+	changes := &activities.ChangeSet{Modules: []string{"app", "vpc"}}
+	snapshot := &activities.StateSnapshot{Key: "dev/dev/vpc/20250101T120000.000000000Z-7.tfstate", Lineage: "b6e1c6a4", Serial: 7}
+
+	var calls []string
+	record := func(call string) func(mock.Arguments) {
+		return func(args mock.Arguments) { calls = append(calls, call) }
+	}
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(changes, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, changes).Return(graph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
+	s.env.OnActivity(activities.BackupState, mock.Anything, input.Url, input.Revision, "vpc", input.Stack, input.StateBackup, 10).Run(record("backup vpc")).Return(snapshot, nil).Once()
+	// A module applied for the first time has no state to back up
+	s.env.OnActivity(activities.BackupState, mock.Anything, input.Url, input.Revision, "app", input.Stack, input.StateBackup, 10).Run(record("backup app")).Return(nil, nil).Once()
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "vpc", input.Stack).Run(record("apply vpc")).Return(&activities.ApplyReport{}, nil).Once()
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "app", input.Stack).Run(record("apply app")).Return(&activities.ApplyReport{}, nil).Once()
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"backup vpc", "apply vpc", "backup app", "apply app"}, calls)
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(map[string]*activities.StateSnapshot{"vpc": snapshot}, result.Snapshots)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_StateBackupFailure() {
+	input := InfraInputs{
+		Url:         "https://github.com/example/repo.git",
+		Revision:    "main",
+		OldRevision: "HEAD~1",
+		Stack:       "dev",
+		StateBackup: "s3://tfstate-backups/dev",
+	}
+	repoPath := "/tmp/infra-12345"
+	graph := activities.NewGraph()
+	graph.AddNode("vpc")
+	changes := &activities.ChangeSet{Modules: []string{"vpc"}}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(changes, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, changes).Return(graph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
+	s.env.OnActivity(activities.BackupState, mock.Anything, input.Url, input.Revision, "vpc", input.Stack, input.StateBackup, 0).Return(
+		nil, temporal.NewNonRetryableApplicationError("failed to upload snapshot", "InvalidInput", nil))
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	// Without a backup the module is not changed
+	s.True(s.env.IsWorkflowCompleted())
+	s.ErrorContains(s.env.GetWorkflowError(), "failed to upload snapshot")
+	s.env.AssertNotCalled(s.T(), "TerragruntApply", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
 func (s *InfraWorkflowTestSuite) TestInfraWorkflow_CloneFailure() {
 	input := InfraInputs{
 		Url:         "https://github.com/example/invalid-repo.git",
@@ -93,7 +504,7 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_TerragruntGraphFailure() {
 	repoPath := "/tmp/infra-12345"
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(
 		(*activities.Graph)(nil), errors.New("terragrunt dag graph failed"))
 
 	s.env.ExecuteWorkflow(Infra, input)
@@ -117,9 +528,9 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ChangedModulesFailure() {
 	}
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
-	s.env.OnActivity(activities.ChangedModules, mock.Anything, repoPath, input.OldRevision).Return(
-		[]string{}, errors.New("git diff failed"))
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(
+		nil, errors.New("git diff failed"))
 
 	s.env.ExecuteWorkflow(Infra, input)
 
@@ -149,11 +560,12 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_TerragruntApplyFailure() {
 	}
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
-	s.env.OnActivity(activities.ChangedModules, mock.Anything, repoPath, input.OldRevision).Return(changedModules, nil)
-	s.env.OnActivity(activities.PruneGraph, mock.Anything, graph, changedModules).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: changedModules}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: changedModules}).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
 	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(
-		errors.New("terragrunt apply failed: resource conflict"))
+		(*activities.ApplyReport)(nil), errors.New("terragrunt apply failed: resource conflict"))
 
 	s.env.ExecuteWorkflow(Infra, input)
 
@@ -209,31 +621,32 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ComplexDependencyGraph() {
 	}
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
-	s.env.OnActivity(activities.ChangedModules, mock.Anything, repoPath, input.OldRevision).Return(changedModules, nil)
-	s.env.OnActivity(activities.PruneGraph, mock.Anything, graph, changedModules).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: changedModules}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: changedModules}).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
 
 	// Mock TerragruntApply calls in dependency order
 	// Level 0: vpc
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "vpc", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "vpc", input.Stack).Return(&activities.ApplyReport{}, nil)
 	// Level 1: database
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "database", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "database", input.Stack).Return(&activities.ApplyReport{}, nil)
 	// Level 2: app
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "app", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "app", input.Stack).Return(&activities.ApplyReport{}, nil)
 	// Level 3: monitoring
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "monitoring", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "monitoring", input.Stack).Return(&activities.ApplyReport{}, nil)
 
 	s.env.ExecuteWorkflow(Infra, input)
 
 	s.True(s.env.IsWorkflowCompleted())
 	s.NoError(s.env.GetWorkflowError())
 
-	var result *activities.Graph
+	var result *InfraResult
 	s.NoError(s.env.GetWorkflowResult(&result))
-	s.True(result.Nodes["vpc"])
-	s.True(result.Nodes["database"])
-	s.True(result.Nodes["app"])
-	s.True(result.Nodes["monitoring"])
+	s.True(result.Graph.Nodes["vpc"])
+	s.True(result.Graph.Nodes["database"])
+	s.True(result.Graph.Nodes["app"])
+	s.True(result.Graph.Nodes["monitoring"])
 }
 
 func (s *InfraWorkflowTestSuite) TestInfraWorkflow_NoChangedModules() {
@@ -263,9 +676,10 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_NoChangedModules() {
 	}
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
-	s.env.OnActivity(activities.ChangedModules, mock.Anything, repoPath, input.OldRevision).Return(changedModules, nil)
-	s.env.OnActivity(activities.PruneGraph, mock.Anything, graph, changedModules).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: changedModules}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: changedModules}).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
 
 	// No TerragruntApply calls should be made since no modules to deploy
 
@@ -274,9 +688,9 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_NoChangedModules() {
 	s.True(s.env.IsWorkflowCompleted())
 	s.NoError(s.env.GetWorkflowError())
 
-	var result *activities.Graph
+	var result *InfraResult
 	s.NoError(s.env.GetWorkflowResult(&result))
-	s.Empty(result.Nodes)
+	s.Empty(result.Graph.Nodes)
 }
 
 func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ActivityTimeout() {
@@ -325,15 +739,16 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ParallelExecution() {
 	prunedGraph := graph // All modules changed
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
-	s.env.OnActivity(activities.ChangedModules, mock.Anything, repoPath, input.OldRevision).Return(changedModules, nil)
-	s.env.OnActivity(activities.PruneGraph, mock.Anything, graph, changedModules).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: changedModules}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: changedModules}).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
 
 	// Level 0: module-c
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module-c", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module-c", input.Stack).Return(&activities.ApplyReport{}, nil)
 	// Level 1: module-a and module-b (should execute in parallel)
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module-a", input.Stack).Return(nil)
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module-b", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module-a", input.Stack).Return(&activities.ApplyReport{}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module-b", input.Stack).Return(&activities.ApplyReport{}, nil)
 
 	s.env.ExecuteWorkflow(Infra, input)
 
@@ -365,21 +780,22 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_WorkerFailureRetry() {
 
 	// Initial workflow activities (successful)
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
-	s.env.OnActivity(activities.ChangedModules, mock.Anything, repoPath, input.OldRevision).Return(changedModules, nil)
-	s.env.OnActivity(activities.PruneGraph, mock.Anything, graph, changedModules).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: changedModules}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: changedModules}).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
 
 	// Simulate worker failure and retry on different worker
 	applyCallCount := 0
 	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(
-		func(ctx context.Context, repoUrl, revision, modulePath, stack string) error {
+		func(ctx context.Context, repoUrl, revision, modulePath, stack string) (*activities.ApplyReport, error) {
 			applyCallCount++
 			if applyCallCount == 1 {
 				// First attempt fails (simulating worker failure)
-				return errors.New("worker failed: connection lost")
+				return nil, errors.New("worker failed: connection lost")
 			}
 			// Second attempt succeeds (activity is self-contained and clones repo again)
-			return nil
+			return &activities.ApplyReport{Module: modulePath}, nil
 		})
 
 	// Mock additional Clone calls for TerragruntApply retries
@@ -391,9 +807,9 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_WorkerFailureRetry() {
 	s.True(s.env.IsWorkflowCompleted())
 	s.NoError(s.env.GetWorkflowError())
 
-	var result *activities.Graph
+	var result *InfraResult
 	s.NoError(s.env.GetWorkflowResult(&result))
-	s.True(result.Nodes["module1"])
+	s.True(result.Graph.Nodes["module1"])
 }
 
 func (s *InfraWorkflowTestSuite) TestInfraWorkflow_NoOldRevisionProvided() {
@@ -427,37 +843,565 @@ func (s *InfraWorkflowTestSuite) TestInfraWorkflow_NoOldRevisionProvided() {
 	// without calling ChangedModules or PruneGraph activities
 
 	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
-	s.env.OnActivity(activities.TerragruntGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
 
 	// Mock TerragruntApply calls in dependency order for all modules
 	// Level 0: vpc
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "vpc", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "vpc", input.Stack).Return(&activities.ApplyReport{}, nil)
 	// Level 1: database, loadbalancer
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "database", input.Stack).Return(nil)
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "loadbalancer", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "database", input.Stack).Return(&activities.ApplyReport{}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "loadbalancer", input.Stack).Return(&activities.ApplyReport{}, nil)
 	// Level 2: app
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "app", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "app", input.Stack).Return(&activities.ApplyReport{}, nil)
 	// Level 3: monitoring
-	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "monitoring", input.Stack).Return(nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "monitoring", input.Stack).Return(&activities.ApplyReport{}, nil)
 
 	s.env.ExecuteWorkflow(Infra, input)
 
 	s.True(s.env.IsWorkflowCompleted())
 	s.NoError(s.env.GetWorkflowError())
 
-	var result *activities.Graph
+	var result *InfraResult
 	s.NoError(s.env.GetWorkflowResult(&result))
 
 	// Verify that all modules are in the result graph
-	s.True(result.Nodes["vpc"])
-	s.True(result.Nodes["database"])
-	s.True(result.Nodes["loadbalancer"])
-	s.True(result.Nodes["app"])
-	s.True(result.Nodes["monitoring"])
+	s.True(result.Graph.Nodes["vpc"])
+	s.True(result.Graph.Nodes["database"])
+	s.True(result.Graph.Nodes["loadbalancer"])
+	s.True(result.Graph.Nodes["app"])
+	s.True(result.Graph.Nodes["monitoring"])
 
 	// Verify that the result graph has the same structure as the original
-	s.Equal(len(graph.Nodes), len(result.Nodes))
-	s.Equal(len(graph.Edges), len(result.Edges))
+	s.Equal(len(graph.Nodes), len(result.Graph.Nodes))
+	s.Equal(len(graph.Edges), len(result.Graph.Edges))
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_PlanMode() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+		Mode:     InfraModePlan,
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"module1": true,
+			"module2": true,
+		},
+		Edges: map[string][]string{
+			"module1": {"module2"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module2", input.Stack).Return(
+		&activities.PlanSummary{Module: "module2", Add: 1}, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(
+		&activities.PlanSummary{Module: "module1", Change: 2, Destroy: 1}, nil)
+
+	// No TerragruntApply calls should be made in plan mode
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Len(result.Plans, 2)
+	s.Equal(1, result.Plans["module2"].Add)
+	s.Equal(2, result.Plans["module1"].Change)
+	s.Equal(1, result.Plans["module1"].Destroy)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_InvalidMode() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+		Mode:     "yolo",
+	}
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "unknown infra mode")
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_DependencyCycle() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"module1": true,
+			"module2": true,
+			"module3": true,
+		},
+		Edges: map[string][]string{
+			"module1": {"module2"},
+			"module2": {"module1"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	var applicationErr *temporal.ApplicationError
+	s.True(errors.As(err, &applicationErr))
+	s.Equal(activities.DependencyCycleError, applicationErr.Type())
+	s.True(applicationErr.NonRetryable())
+	s.Contains(err.Error(), "module1 -> module2 -> module1")
+	s.env.AssertNotCalled(s.T(), "TerragruntApply", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ApprovalAutoApprovesWithoutDestroy() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+		Approval: ApprovalOnDestroy,
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{"module1": true},
+		Edges: map[string][]string{},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(
+		&activities.PlanSummary{Module: "module1", Add: 3}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(&activities.ApplyReport{}, nil)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ApprovalWaitsForSignal() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+		Approval: ApprovalOnDestroy,
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"module1": true,
+			"module2": true,
+		},
+		Edges: map[string][]string{
+			"module1": {"module2"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module2", input.Stack).Return(
+		&activities.PlanSummary{Module: "module2", Destroy: 1}, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(
+		&activities.PlanSummary{Module: "module1", Change: 1}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module2", input.Stack).Return(&activities.ApplyReport{}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(&activities.ApplyReport{}, nil)
+
+	s.env.RegisterDelayedCallback(func() {
+		value, err := s.env.QueryWorkflow(PendingPlansQuery)
+		s.NoError(err)
+		var pending map[string]*activities.PlanSummary
+		s.NoError(value.Get(&pending))
+		s.Len(pending, 1)
+		s.Equal(1, pending["module2"].Destroy)
+
+		s.env.SignalWorkflow(ApprovalSignalName, ApprovalSignal{Decision: ApprovalApprove})
+	}, time.Minute)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ModuleApplied, result.Outcomes["module1"].Status)
+	s.Equal(ModuleApplied, result.Outcomes["module2"].Status)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ApprovalRejectSkipsDependents() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+		Approval: ApprovalAlways,
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"module1": true,
+			"module2": true,
+			"module3": true,
+		},
+		Edges: map[string][]string{
+			"module1": {"module2"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module2", input.Stack).Return(
+		&activities.PlanSummary{Module: "module2", Add: 1}, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module3", input.Stack).Return(
+		&activities.PlanSummary{Module: "module3", Add: 1}, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module3", input.Stack).Return(&activities.ApplyReport{}, nil)
+
+	// module1 is never planned nor applied because module2 was rejected
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApprovalSignalName, ApprovalSignal{Decision: ApprovalReject, Modules: []string{"module2"}})
+	}, time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApprovalSignalName, ApprovalSignal{Decision: ApprovalApprove, Modules: []string{"module3"}})
+	}, 2*time.Minute)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ModuleRejected, result.Outcomes["module2"].Status)
+	s.Equal(ModuleSkipped, result.Outcomes["module1"].Status)
+	s.Equal("module2", result.Outcomes["module1"].Dependency)
+	s.Equal(ModuleApplied, result.Outcomes["module3"].Status)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_PlanErrorNotRetried() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+	}
+	repoPath := "/tmp/infra-12345"
+	graph := activities.NewGraph()
+	graph.AddNode("module1")
+
+	// The activity leaves retrying to the policy, which must not rerun a plan that fails the same way every time
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(
+		(*activities.ApplyReport)(nil), temporal.NewApplicationError("invalid count argument", activities.TerraformPlanError)).Once()
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "invalid count argument")
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_ContinueOnFailure() {
+	input := InfraInputs{
+		Url:           "https://github.com/example/repo.git",
+		Revision:      "main",
+		Stack:         "dev",
+		FailurePolicy: FailContinue,
+	}
+	repoPath := "/tmp/infra-12345"
+
+	// Two independent branches:
+	// app -> database -> vpc
+	// dns
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"vpc":      true,
+			"database": true,
+			"app":      true,
+			"dns":      true,
+		},
+		Edges: map[string][]string{
+			"database": {"vpc"},
+			"app":      {"database"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "vpc", input.Stack).Return(
+		(*activities.ApplyReport)(nil), temporal.NewNonRetryableApplicationError("quota exceeded", activities.TerraformPlanError, nil))
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "dns", input.Stack).Return(&activities.ApplyReport{}, nil)
+
+	// database and app are never applied because vpc failed
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	err := s.env.GetWorkflowError()
+	s.Error(err)
+	s.Contains(err.Error(), "1 of 4 modules failed")
+
+	var applicationErr *temporal.ApplicationError
+	s.True(errors.As(err, &applicationErr))
+	var result *InfraResult
+	s.NoError(applicationErr.Details(&result))
+	s.Equal(ModuleFailed, result.Outcomes["vpc"].Status)
+	s.Contains(result.Outcomes["vpc"].Error, "quota exceeded")
+	s.Equal(ModuleSkipped, result.Outcomes["database"].Status)
+	s.Equal("vpc", result.Outcomes["database"].Dependency)
+	s.Equal(ModuleSkipped, result.Outcomes["app"].Status)
+	s.Equal("database", result.Outcomes["app"].Dependency)
+	s.Equal(ModuleApplied, result.Outcomes["dns"].Status)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_FailFastStopsIndependentBranches() {
+	input := InfraInputs{
+		Url:      "https://github.com/example/repo.git",
+		Revision: "main",
+		Stack:    "dev",
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"vpc":      true,
+			"database": true,
+			"dns":      true,
+			"records":  true,
+		},
+		Edges: map[string][]string{
+			"database": {"vpc"},
+			"records":  {"dns"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "vpc", input.Stack).Return(
+		(*activities.ApplyReport)(nil), temporal.NewNonRetryableApplicationError("quota exceeded", activities.TerraformPlanError, nil))
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "dns", input.Stack).Return(&activities.ApplyReport{}, nil)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "quota exceeded")
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_GraphQuery() {
+	input := InfraInputs{
+		Url:         "https://github.com/example/repo.git",
+		Revision:    "main",
+		OldRevision: "HEAD~1",
+		Stack:       "dev",
+		Mode:        InfraModePlan,
+	}
+	repoPath := "/tmp/infra-12345"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"module1": true,
+			"module2": true,
+			"module3": true,
+		},
+		Edges: map[string][]string{
+			"module1": {"module2"},
+		},
+	}
+	prunedGraph := &activities.Graph{
+		Nodes: map[string]bool{
+			"module1": true,
+			"module2": true,
+		},
+		Edges: map[string][]string{
+			"module1": {"module2"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: []string{"module2"}}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: []string{"module2"}}).Return(prunedGraph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(nil, nil)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, mock.Anything, input.Stack).Return(&activities.PlanSummary{}, nil)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	value, err := s.env.QueryWorkflow(GraphQuery, activities.GraphFormatJSON)
+	s.NoError(err)
+	var rendered string
+	s.NoError(value.Get(&rendered))
+	s.JSONEq(`{
+		"version": 1,
+		"nodes": [
+			{"id": "module1", "mark": "dependent"},
+			{"id": "module2", "mark": "changed"},
+			{"id": "module3", "mark": "skipped"}
+		],
+		"edges": [{"from": "module1", "to": "module2"}]
+	}`, rendered)
+
+	value, err = s.env.QueryWorkflow(GraphQuery, activities.GraphFormat(""))
+	s.NoError(err)
+	s.NoError(value.Get(&rendered))
+	s.Contains(rendered, `"module2" [class="changed"`)
+
+	_, err = s.env.QueryWorkflow(GraphQuery, activities.GraphFormat("svg"))
+	s.ErrorContains(err, "unknown graph format")
+}
+
+func (s *InfraWorkflowTestSuite) removedModulesSetup(input InfraInputs) {
+	repoPath := "/tmp/infra-12345"
+	oldRepoPath := "/tmp/infra-67890"
+
+	graph := &activities.Graph{
+		Nodes: map[string]bool{"module1": true},
+		Edges: map[string][]string{},
+	}
+	oldGraph := &activities.Graph{
+		Nodes: map[string]bool{
+			"module1":  true,
+			"database": true,
+			"app":      true,
+		},
+		Edges: map[string][]string{
+			"app": {"database"},
+		},
+	}
+
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.Revision).Return(repoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, repoPath+"/infra/"+input.Stack).Return(graph, nil)
+	s.env.OnActivity(activities.DetectChanges, mock.Anything, repoPath, input.OldRevision, input.Stack).Return(&activities.ChangeSet{Modules: []string{"module1"}}, nil)
+	s.env.OnActivity(activities.PruneChanges, mock.Anything, graph, &activities.ChangeSet{Modules: []string{"module1"}}).Return(graph, nil)
+	s.env.OnActivity(activities.RemovedModules, mock.Anything, repoPath, input.OldRevision, input.Stack).Return([]string{"app", "database"}, nil)
+	s.env.OnActivity(activities.Clone, mock.Anything, input.Url, input.OldRevision).Return(oldRepoPath, nil)
+	s.env.OnActivity(activities.HCLGraph, mock.Anything, oldRepoPath+"/infra/"+input.Stack).Return(oldGraph, nil)
+	s.env.OnActivity(activities.TerragruntPlanDestroy, mock.Anything, input.Url, input.OldRevision, "app", input.Stack).Return(
+		&activities.PlanSummary{Module: "app", Destroy: 2}, nil)
+	s.env.OnActivity(activities.TerragruntPlanDestroy, mock.Anything, input.Url, input.OldRevision, "database", input.Stack).Return(
+		&activities.PlanSummary{Module: "database", Destroy: 1}, nil)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_RemovedModulesNeedExplicitConfirmation() {
+	input := InfraInputs{
+		Url:         "https://github.com/example/repo.git",
+		Revision:    "main",
+		OldRevision: "abc123",
+		Stack:       "dev",
+	}
+	s.removedModulesSetup(input)
+
+	var destroyed []string
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(&activities.ApplyReport{}, nil)
+	s.env.OnActivity(activities.TerragruntDestroy, mock.Anything, input.Url, input.OldRevision, mock.Anything, input.Stack).Return(
+		func(ctx context.Context, url string, revision string, module string, stack string) error {
+			destroyed = append(destroyed, module)
+			return nil
+		})
+
+	s.env.RegisterDelayedCallback(func() {
+		value, err := s.env.QueryWorkflow(PendingPlansQuery)
+		s.NoError(err)
+		var pending map[string]*activities.PlanSummary
+		s.NoError(value.Get(&pending))
+		s.Len(pending, 1)
+		s.Equal(2, pending["app"].Destroy)
+
+		// Approving everything does not confirm a removal
+		s.env.SignalWorkflow(ApprovalSignalName, ApprovalSignal{Decision: ApprovalApprove})
+	}, time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.Empty(destroyed)
+		s.env.SignalWorkflow(ApprovalSignalName, ApprovalSignal{Decision: ApprovalApprove, Modules: []string{"app"}})
+	}, 2*time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApprovalSignalName, ApprovalSignal{Decision: ApprovalApprove, Modules: []string{"database"}})
+	}, 3*time.Minute)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"app", "database"}, destroyed)
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal([]string{"app", "database"}, result.Removed)
+	s.Equal(ModuleApplied, result.Outcomes["module1"].Status)
+	s.Equal(ModuleDestroyed, result.Outcomes["app"].Status)
+	s.Equal(ModuleDestroyed, result.Outcomes["database"].Status)
+	s.Equal(1, result.Plans["database"].Destroy)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_RemovedModuleRejectedKeepsDependencies() {
+	input := InfraInputs{
+		Url:         "https://github.com/example/repo.git",
+		Revision:    "main",
+		OldRevision: "abc123",
+		Stack:       "dev",
+	}
+	s.removedModulesSetup(input)
+	s.env.OnActivity(activities.TerragruntApply, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(&activities.ApplyReport{}, nil)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(ApprovalSignalName, ApprovalSignal{Decision: ApprovalReject, Modules: []string{"app"}})
+	}, time.Minute)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertNotCalled(s.T(), "TerragruntDestroy", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ModuleRejected, result.Outcomes["app"].Status)
+	s.Equal(ModuleSkipped, result.Outcomes["database"].Status)
+	s.Equal("app", result.Outcomes["database"].Dependency)
+}
+
+func (s *InfraWorkflowTestSuite) TestInfraWorkflow_RemovedModulesPlanMode() {
+	input := InfraInputs{
+		Url:         "https://github.com/example/repo.git",
+		Revision:    "main",
+		OldRevision: "abc123",
+		Stack:       "dev",
+		Mode:        InfraModePlan,
+	}
+	s.removedModulesSetup(input)
+	s.env.OnActivity(activities.TerragruntPlan, mock.Anything, input.Url, input.Revision, "module1", input.Stack).Return(
+		&activities.PlanSummary{Module: "module1"}, nil)
+
+	s.env.ExecuteWorkflow(Infra, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.env.AssertNotCalled(s.T(), "TerragruntDestroy", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	var result *InfraResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(ModulePlanned, result.Outcomes["app"].Status)
+	s.Equal(ModulePlanned, result.Outcomes["database"].Status)
+	s.Equal(2, result.Plans["app"].Destroy)
 }
 
 func TestInfraWorkflowTestSuite(t *testing.T) {
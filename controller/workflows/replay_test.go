@@ -0,0 +1,233 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"github.com/stretchr/testify/require"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/temporalproto"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/worker"
+)
+
+// Recorded Infra histories live in testdata/replay, regenerate them against a running Temporal server with
+//
+//	TEMPORAL_RECORD_HOST=localhost:7233 go test ./workflows -run TestRecordInfraHistories
+//
+// Only record new scenarios, e.g. -run TestRecordInfraHistories/infra_removed_modules, histories recorded
+// before a workflow change are what proves the change stays compatible with running workflows.
+const replayDir = "testdata/replay"
+
+func TestReplayInfraHistories(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(replayDir, "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files, "no recorded histories in %s", replayDir)
+
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			replayer := worker.NewWorkflowReplayer()
+			replayer.RegisterWorkflow(Infra)
+			require.NoError(t, replayer.ReplayWorkflowHistoryFromJSONFile(nil, file))
+		})
+	}
+}
+
+// replayScenario drives one recorded Infra run with stubbed activities
+type replayScenario struct {
+	name    string
+	input   InfraInputs
+	graph   *activities.Graph
+	changed []string
+	// removed modules exist at OldRevision only, oldGraph is the graph at OldRevision
+	removed  []string
+	oldGraph *activities.Graph
+	// failing modules return a non-retryable error from plan and apply
+	failing map[string]bool
+	// approve sends an approval signal once plans are pending
+	approve bool
+}
+
+func replayGraph() *activities.Graph {
+	graph := activities.NewGraph()
+	graph.AddEdge("database", "vpc")
+	graph.AddEdge("cache", "vpc")
+	graph.AddEdge("app", "database")
+	graph.AddEdge("app", "cache")
+	graph.AddNode("dns")
+	return graph
+}
+
+func replayScenarios() []replayScenario {
+	input := InfraInputs{
+		Url:      "https://github.com/example/infra.git",
+		Revision: "master",
+		Stack:    "local",
+	}
+
+	plan := input
+	plan.Mode = InfraModePlan
+	plan.OldRevision = "HEAD~1"
+
+	failure := input
+	failure.FailurePolicy = FailContinue
+	failure.MaxParallelism = 2
+
+	approval := input
+	approval.Approval = ApprovalAlways
+
+	removal := input
+	removal.OldRevision = "HEAD~1"
+	oldGraph := replayGraph()
+	oldGraph.AddEdge("queue", "vpc")
+	oldGraph.AddEdge("worker", "queue")
+
+	return []replayScenario{
+		{name: "infra_apply", input: input, graph: replayGraph()},
+		{name: "infra_plan_pruned", input: plan, graph: replayGraph(), changed: []string{"database"}},
+		{name: "infra_continue_on_failure", input: failure, graph: replayGraph(), failing: map[string]bool{"cache": true}},
+		{name: "infra_approval", input: approval, graph: replayGraph(), approve: true},
+		{name: "infra_removed_modules", input: removal, graph: replayGraph(), changed: []string{"dns"}, removed: []string{"queue", "worker"}, oldGraph: oldGraph, approve: true},
+		{name: "infra_change_reasons", input: plan, graph: replayGraph(), changed: []string{"vpc"}},
+		{name: "infra_native_graph", input: removal, graph: replayGraph(), changed: []string{"cache"}, removed: []string{"queue", "worker"}, oldGraph: oldGraph, approve: true},
+		{name: "infra_stack_lock", input: removal, graph: replayGraph(), changed: []string{"cache"}, removed: []string{"queue", "worker"}, oldGraph: oldGraph, approve: true},
+	}
+}
+
+func (r replayScenario) register(w worker.Worker) {
+	w.RegisterWorkflow(Infra)
+	w.RegisterWorkflow(StackLock)
+	w.RegisterActivity(activities.RequestStackLock)
+	w.RegisterActivity(activities.WorkflowRunning)
+	w.RegisterActivityWithOptions(func(ctx context.Context, url string, revision string) (string, error) {
+		return "/tmp/replay-" + r.name + "-" + revision, nil
+	}, activity.RegisterOptions{Name: "Clone"})
+	graph := func(ctx context.Context, path string) (*activities.Graph, error) {
+		if strings.Contains(path, "-"+r.input.OldRevision+"/") {
+			return r.oldGraph, nil
+		}
+		return r.graph, nil
+	}
+	w.RegisterActivityWithOptions(graph, activity.RegisterOptions{Name: "TerragruntGraph"})
+	w.RegisterActivityWithOptions(graph, activity.RegisterOptions{Name: "HCLGraph"})
+	w.RegisterActivityWithOptions(func(ctx context.Context, repoPath string, oldRevision string) ([]string, error) {
+		return r.changed, nil
+	}, activity.RegisterOptions{Name: "ChangedModules"})
+	w.RegisterActivityWithOptions(func(ctx context.Context, repoPath string, oldRevision string, stack string) (*activities.ChangeSet, error) {
+		files := make(map[string]string)
+		for _, module := range r.changed {
+			files[module] = "infra/" + r.input.Stack + "/" + module + "/terragrunt.hcl"
+		}
+		return &activities.ChangeSet{Modules: r.changed, Files: files}, nil
+	}, activity.RegisterOptions{Name: "DetectChanges"})
+	w.RegisterActivityWithOptions(func(ctx context.Context, repoPath string, oldRevision string, stack string) ([]string, error) {
+		return r.removed, nil
+	}, activity.RegisterOptions{Name: "RemovedModules"})
+	w.RegisterActivityWithOptions(func(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*activities.PlanSummary, error) {
+		return &activities.PlanSummary{Module: modulePath, Destroy: 1}, nil
+	}, activity.RegisterOptions{Name: "TerragruntPlanDestroy"})
+	w.RegisterActivityWithOptions(func(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) error {
+		return nil
+	}, activity.RegisterOptions{Name: "TerragruntDestroy"})
+	w.RegisterActivity(activities.PruneGraph)
+	w.RegisterActivity(activities.PruneChanges)
+	w.RegisterActivityWithOptions(func(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*activities.PlanSummary, error) {
+		if r.failing[modulePath] {
+			return nil, temporal.NewNonRetryableApplicationError("plan failed", activities.TerraformPlanError, nil)
+		}
+		return &activities.PlanSummary{Module: modulePath, Add: 1}, nil
+	}, activity.RegisterOptions{Name: "TerragruntPlan"})
+	w.RegisterActivityWithOptions(func(ctx context.Context, repoUrl string, revision string, modulePath string, stack string) (*activities.ApplyReport, error) {
+		if r.failing[modulePath] {
+			return nil, temporal.NewNonRetryableApplicationError("apply failed", activities.TerraformValidationError, nil)
+		}
+		return &activities.ApplyReport{Module: modulePath, Add: 1}, nil
+	}, activity.RegisterOptions{Name: "TerragruntApply"})
+}
+
+func TestRecordInfraHistories(t *testing.T) {
+	host := os.Getenv("TEMPORAL_RECORD_HOST")
+	if host == "" {
+		t.Skip("TEMPORAL_RECORD_HOST is not set")
+	}
+
+	c, err := client.Dial(client.Options{
+		HostPort:  host,
+		Namespace: os.Getenv("TEMPORAL_RECORD_NAMESPACE"),
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, os.MkdirAll(replayDir, 0o755))
+
+	for _, scenario := range replayScenarios() {
+		t.Run(scenario.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			taskQueue := "replay-" + scenario.name
+			w := worker.New(c, taskQueue, worker.Options{})
+			scenario.register(w)
+			require.NoError(t, w.Start())
+			defer w.Stop()
+
+			run, err := c.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+				ID:        fmt.Sprintf("%s-%d", taskQueue, time.Now().UnixNano()),
+				TaskQueue: taskQueue,
+			}, Infra, scenario.input)
+			require.NoError(t, err)
+
+			if scenario.approve {
+				// Dependents only wait for approval once their dependencies are applied, so keep approving until the run closes
+				require.Eventually(t, func() bool {
+					description, err := c.DescribeWorkflowExecution(ctx, run.GetID(), run.GetRunID())
+					require.NoError(t, err)
+					if description.WorkflowExecutionInfo.Status != enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING {
+						return true
+					}
+					value, err := c.QueryWorkflow(ctx, run.GetID(), run.GetRunID(), PendingPlansQuery)
+					if err != nil {
+						return false
+					}
+					var pending map[string]*activities.PlanSummary
+					if value.Get(&pending) == nil && len(pending) > 0 {
+						// Removed modules are only confirmed by name
+						var modules []string
+						for module := range pending {
+							modules = append(modules, module)
+						}
+						sort.Strings(modules)
+						require.NoError(t, c.SignalWorkflow(ctx, run.GetID(), run.GetRunID(), ApprovalSignalName, ApprovalSignal{Decision: ApprovalApprove, Modules: modules}))
+					}
+					return false
+				}, 30*time.Second, 200*time.Millisecond)
+			}
+
+			// Failed runs are recorded too, only the history matters
+			_ = run.Get(ctx, nil)
+
+			history := &historypb.History{}
+			iter := c.GetWorkflowHistory(ctx, run.GetID(), run.GetRunID(), false, 0)
+			for iter.HasNext() {
+				event, err := iter.Next()
+				require.NoError(t, err)
+				history.Events = append(history.Events, event)
+			}
+
+			data, err := temporalproto.CustomJSONMarshalOptions{Indent: "  "}.Marshal(history)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(replayDir, scenario.name+".json"), append(data, '\n'), 0o644))
+		})
+	}
+}
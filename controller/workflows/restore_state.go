@@ -0,0 +1,67 @@
+package workflows
+
+import (
+	"fmt"
+
+	"cloudlab/controller/activities"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+type RestoreStateInputs struct {
+	Url      string
+	Revision string
+	Stack    string
+	Module   string
+	// StateBackup is the s3://<bucket>/<prefix> location the Infra workflow backed the state up to
+	StateBackup string
+	// Snapshot is the key of the snapshot to restore, as reported in the Snapshots of an Infra result
+	Snapshot string
+	// Confirm must be set to the snapshot key again
+	Confirm string
+}
+
+type RestoreStateResult struct {
+	// Restored is the snapshot that is now the state of the module, with the serial it was pushed with
+	Restored *activities.StateSnapshot
+	// Backup is the state the module had before, restore it to undo the rollback
+	Backup *activities.StateSnapshot `json:",omitempty"`
+}
+
+// RestoreState rolls the state of a module back to a snapshot taken by the Infra workflow. It holds the lock of
+// the stack so that no apply runs meanwhile, and backs the current state up first.
+func RestoreState(ctx workflow.Context, input RestoreStateInputs) (*RestoreStateResult, error) {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("RestoreState workflow started", "restore", input)
+
+	if input.Module == "" || input.StateBackup == "" || input.Snapshot == "" {
+		return nil, temporal.NewNonRetryableApplicationError("module, stateBackup and snapshot are required", "InvalidInput", nil)
+	}
+	if input.Confirm != input.Snapshot {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("restoring module %q requires confirm to be set to the snapshot key", input.Module),
+			"ConfirmationRequired",
+			nil,
+		)
+	}
+
+	_, release, err := acquireStackLock(ctx, input.Stack, activities.StackLockRequest{Revision: input.Revision})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	moduleCtx := moduleContext(ctx, input.Stack, input.Module)
+
+	result := &RestoreStateResult{}
+	if err := workflow.ExecuteActivity(moduleCtx, activities.BackupState, input.Url, input.Revision, input.Module, input.Stack, input.StateBackup, 0).Get(ctx, &result.Backup); err != nil {
+		return nil, err
+	}
+	if err := workflow.ExecuteActivity(moduleCtx, activities.RestoreStateSnapshot, input.Url, input.Revision, input.Module, input.Stack, input.StateBackup, input.Snapshot).Get(ctx, &result.Restored); err != nil {
+		return nil, err
+	}
+
+	logger.Info("RestoreState workflow completed", "module", input.Module, "snapshot", input.Snapshot, "serial", result.Restored.Serial)
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package workflows
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+type RestoreStateWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+}
+
+func (s *RestoreStateWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.SetTestTimeout(30 * time.Second)
+}
+
+func (s *RestoreStateWorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+func (s *RestoreStateWorkflowTestSuite) input() RestoreStateInputs {
+	return RestoreStateInputs{
+		Url:         "https://github.com/example/repo.git",
+		Revision:    "main",
+		Stack:       "production",
+		Module:      "cluster",
+		StateBackup: "s3://tfstate-backups/production",
+		Snapshot:    "production/cluster/20250101T120000.000000000Z-7.tfstate",
+		Confirm:     "production/cluster/20250101T120000.000000000Z-7.tfstate",
+	}
+}
+
+func (s *RestoreStateWorkflowTestSuite) TestRestoreStateWorkflow_Success() {
+	input := s.input()
+	backup := &activities.StateSnapshot{Key: "production/cluster/20250102T120000.000000000Z-9.tfstate", Lineage: "b6e1c6a4", Serial: 9}
+	// Snapshot 7 is pushed as the version after the backup of the current state
+	restored := &activities.StateSnapshot{Key: input.Snapshot, Lineage: "b6e1c6a4", Serial: 10, SnapshotSerial: 7}
+
+	var calls []string
+	var request activities.StackLockRequest
+	s.env.OnActivity(activities.RequestStackLock, mock.Anything, input.Stack, mock.Anything).Return(func(ctx context.Context, stack string, r activities.StackLockRequest) error {
+		calls = append(calls, "lock")
+		request = r
+		s.env.SignalWorkflow(StackLockGrantSignal, StackLockGrant{})
+		return nil
+	}).Once()
+	s.env.OnActivity(activities.BackupState, mock.Anything, input.Url, input.Revision, input.Module, input.Stack, input.StateBackup, 0).
+		Run(func(args mock.Arguments) { calls = append(calls, "backup") }).Return(backup, nil).Once()
+	s.env.OnActivity(activities.RestoreStateSnapshot, mock.Anything, input.Url, input.Revision, input.Module, input.Stack, input.StateBackup, input.Snapshot).
+		Run(func(args mock.Arguments) { calls = append(calls, "restore") }).Return(restored, nil).Once()
+	s.env.OnSignalExternalWorkflow(mock.Anything, activities.StackLockWorkflowID(input.Stack), "", StackLockReleaseSignal, mock.Anything).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(RestoreState, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"lock", "backup", "restore"}, calls)
+	// A rollback is never replaced by a newer run
+	s.False(request.Supersedable)
+
+	var result *RestoreStateResult
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(&RestoreStateResult{Restored: restored, Backup: backup}, result)
+}
+
+func (s *RestoreStateWorkflowTestSuite) TestRestoreStateWorkflow_SnapshotRejected() {
+	input := s.input()
+	grantStackLock(s.env)
+
+	s.env.OnActivity(activities.BackupState, mock.Anything, input.Url, input.Revision, input.Module, input.Stack, input.StateBackup, 0).Return(nil, nil).Once()
+	s.env.OnActivity(activities.RestoreStateSnapshot, mock.Anything, input.Url, input.Revision, input.Module, input.Stack, input.StateBackup, input.Snapshot).Return(
+		nil, temporal.NewNonRetryableApplicationError("snapshot of module cluster has lineage 0f3d2e1c, the state has lineage b6e1c6a4", activities.StateSnapshotError, nil)).Once()
+
+	s.env.ExecuteWorkflow(RestoreState, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	var appErr *temporal.ApplicationError
+	s.ErrorAs(s.env.GetWorkflowError(), &appErr)
+	s.Equal(activities.StateSnapshotError, appErr.Type())
+}
+
+func (s *RestoreStateWorkflowTestSuite) TestRestoreStateWorkflow_Refused() {
+	testCases := []struct {
+		name     string
+		input    func(*RestoreStateInputs)
+		errType  string
+		expected string
+	}{
+		{
+			name:     "no confirmation",
+			input:    func(input *RestoreStateInputs) { input.Confirm = "" },
+			errType:  "ConfirmationRequired",
+			expected: `restoring module "cluster" requires confirm to be set to the snapshot key`,
+		},
+		{
+			name: "confirmation of another snapshot",
+			input: func(input *RestoreStateInputs) {
+				input.Confirm = "production/cluster/20250102T120000.000000000Z-9.tfstate"
+			},
+			errType:  "ConfirmationRequired",
+			expected: `restoring module "cluster" requires confirm to be set to the snapshot key`,
+		},
+		{
+			name:     "no backup location",
+			input:    func(input *RestoreStateInputs) { input.StateBackup = "" },
+			errType:  "InvalidInput",
+			expected: "module, stateBackup and snapshot are required",
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			env := s.NewTestWorkflowEnvironment()
+			input := s.input()
+			tc.input(&input)
+
+			env.ExecuteWorkflow(RestoreState, input)
+
+			s.True(env.IsWorkflowCompleted())
+			var appErr *temporal.ApplicationError
+			s.ErrorAs(env.GetWorkflowError(), &appErr)
+			s.Equal(tc.errType, appErr.Type())
+			s.Contains(appErr.Error(), tc.expected)
+		})
+	}
+}
+
+func TestRestoreStateWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(RestoreStateWorkflowTestSuite))
+}
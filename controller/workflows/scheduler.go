@@ -0,0 +1,115 @@
+package workflows
+
+import (
+	"fmt"
+	"sort"
+
+	"cloudlab/controller/activities"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// nodeRunner runs a single node and reports whether its dependents may run.
+// Returning an error stops the schedule.
+type nodeRunner func(ctx workflow.Context, node string) (bool, error)
+
+// nodeSkipper is called instead of a runner for nodes with a dependency that did not succeed
+type nodeSkipper func(node string, dependency string)
+
+type nodeResult struct {
+	node string
+	ok   bool
+	err  error
+}
+
+// scheduleGraph starts each node of the graph as soon as all of its dependencies have finished,
+// running at most maxParallelism nodes at a time, or all ready nodes if it is zero.
+// Ready nodes are always started in sorted order so that replays schedule activities identically.
+func scheduleGraph(ctx workflow.Context, graph *activities.Graph, maxParallelism int, run nodeRunner, skip nodeSkipper) error {
+	nodes := graph.GetNodes()
+	sort.Strings(nodes)
+
+	waiting := make(map[string]int, len(nodes))
+	var ready []string
+	for _, node := range nodes {
+		waiting[node] = len(graph.Dependencies(node))
+		if waiting[node] == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	blocked := make(map[string]bool)
+	results := workflow.NewChannel(ctx)
+	running := 0
+	finished := 0
+
+	// finish releases the dependents of a node, skipping those with a blocked dependency
+	var finish func(node string, ok bool)
+	finish = func(node string, ok bool) {
+		finished++
+		if !ok {
+			blocked[node] = true
+		}
+		for _, dependent := range graph.Dependents(node) {
+			waiting[dependent]--
+			if waiting[dependent] > 0 {
+				continue
+			}
+			if dependency := firstBlocked(graph.Dependencies(dependent), blocked); dependency != "" {
+				skip(dependent, dependency)
+				finish(dependent, false)
+				continue
+			}
+			ready = insertSorted(ready, dependent)
+		}
+	}
+
+	for finished < len(nodes) {
+		for len(ready) > 0 && (maxParallelism <= 0 || running < maxParallelism) {
+			node := ready[0]
+			ready = ready[1:]
+			running++
+			workflow.Go(ctx, func(ctx workflow.Context) {
+				ok, err := run(ctx, node)
+				results.Send(ctx, nodeResult{node: node, ok: ok, err: err})
+			})
+		}
+
+		if running == 0 {
+			var unscheduled []string
+			for _, node := range nodes {
+				if waiting[node] > 0 {
+					unscheduled = append(unscheduled, node)
+				}
+			}
+			return fmt.Errorf("unable to schedule %v, the graph contains a cycle", unscheduled)
+		}
+
+		var result nodeResult
+		results.Receive(ctx, &result)
+		running--
+		if result.err != nil {
+			return result.err
+		}
+		finish(result.node, result.ok)
+	}
+
+	return nil
+}
+
+func firstBlocked(nodes []string, blocked map[string]bool) string {
+	for _, node := range nodes {
+		if blocked[node] {
+			return node
+		}
+	}
+	return ""
+}
+
+func insertSorted(nodes []string, node string) []string {
+	i := sort.SearchStrings(nodes, node)
+	nodes = append(nodes, "")
+	copy(nodes[i+1:], nodes[i:])
+	nodes[i] = node
+	return nodes
+}
@@ -0,0 +1,189 @@
+package workflows
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+type SchedulerTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+}
+
+func (s *SchedulerTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.SetTestTimeout(30 * time.Second)
+}
+
+type scheduleTestInput struct {
+	Graph          *activities.Graph
+	MaxParallelism int
+	// Minutes each node takes, one minute if missing
+	Minutes map[string]int
+	Failing []string
+}
+
+// scheduleTestWorkflow records "start node", "end node" and "skip node" events with their minute offset
+func scheduleTestWorkflow(ctx workflow.Context, input scheduleTestInput) ([]string, error) {
+	begin := workflow.Now(ctx)
+	var events []string
+	record := func(ctx workflow.Context, event string, node string) {
+		events = append(events, fmt.Sprintf("%d %s %s", int(workflow.Now(ctx).Sub(begin).Minutes()), event, node))
+	}
+
+	run := func(ctx workflow.Context, node string) (bool, error) {
+		record(ctx, "start", node)
+		minutes := input.Minutes[node]
+		if minutes == 0 {
+			minutes = 1
+		}
+		if err := workflow.Sleep(ctx, time.Duration(minutes)*time.Minute); err != nil {
+			return false, err
+		}
+		record(ctx, "end", node)
+		for _, failing := range input.Failing {
+			if failing == node {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	skip := func(node string, dependency string) {
+		events = append(events, fmt.Sprintf("skip %s via %s", node, dependency))
+	}
+
+	err := scheduleGraph(ctx, input.Graph, input.MaxParallelism, run, skip)
+	return events, err
+}
+
+func (s *SchedulerTestSuite) execute(input scheduleTestInput) []string {
+	s.env.ExecuteWorkflow(scheduleTestWorkflow, input)
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var events []string
+	s.NoError(s.env.GetWorkflowResult(&events))
+	return events
+}
+
+func (s *SchedulerTestSuite) TestStartsModulesWhenTheirOwnDependenciesFinish() {
+	// bootstrap -> cluster (slow), dns -> network
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"cluster":   true,
+			"bootstrap": true,
+			"network":   true,
+			"dns":       true,
+		},
+		Edges: map[string][]string{
+			"bootstrap": {"cluster"},
+			"dns":       {"network"},
+		},
+	}
+
+	events := s.execute(scheduleTestInput{
+		Graph:   graph,
+		Minutes: map[string]int{"cluster": 10},
+	})
+
+	s.Equal([]string{
+		"0 start cluster",
+		"0 start network",
+		"1 end network",
+		"1 start dns",
+		"2 end dns",
+		"10 end cluster",
+		"10 start bootstrap",
+		"11 end bootstrap",
+	}, events)
+}
+
+func (s *SchedulerTestSuite) TestMaxParallelism() {
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"a": true,
+			"b": true,
+			"c": true,
+		},
+		Edges: map[string][]string{
+			"a": {"c"},
+		},
+	}
+
+	events := s.execute(scheduleTestInput{
+		Graph:          graph,
+		MaxParallelism: 1,
+	})
+
+	s.Equal([]string{
+		"0 start b",
+		"1 end b",
+		"1 start c",
+		"2 end c",
+		"2 start a",
+		"3 end a",
+	}, events)
+}
+
+func (s *SchedulerTestSuite) TestSkipsTransitiveDependentsOfFailedNodes() {
+	// app -> database -> vpc, dns
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"vpc":      true,
+			"database": true,
+			"app":      true,
+			"dns":      true,
+		},
+		Edges: map[string][]string{
+			"database": {"vpc"},
+			"app":      {"database"},
+		},
+	}
+
+	events := s.execute(scheduleTestInput{
+		Graph:   graph,
+		Minutes: map[string]int{"dns": 5},
+		Failing: []string{"vpc"},
+	})
+
+	s.Equal([]string{
+		"0 start dns",
+		"0 start vpc",
+		"1 end vpc",
+		"skip database via vpc",
+		"skip app via database",
+		"5 end dns",
+	}, events)
+}
+
+func (s *SchedulerTestSuite) TestCycle() {
+	graph := &activities.Graph{
+		Nodes: map[string]bool{
+			"a": true,
+			"b": true,
+		},
+		Edges: map[string][]string{
+			"a": {"b"},
+			"b": {"a"},
+		},
+	}
+
+	s.env.ExecuteWorkflow(scheduleTestWorkflow, scheduleTestInput{Graph: graph})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Error(s.env.GetWorkflowError())
+	s.Contains(s.env.GetWorkflowError().Error(), "cycle")
+}
+
+func TestSchedulerTestSuite(t *testing.T) {
+	suite.Run(t, new(SchedulerTestSuite))
+}
@@ -0,0 +1,217 @@
+package workflows
+
+import (
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// StackLockGrantSignal tells a queued run it holds the lock of its stack, or that it was superseded
+	StackLockGrantSignal = "stack-lock-grant"
+	// StackLockReleaseSignal releases the lock, or withdraws a queued request, its argument is the StackLockRequest
+	StackLockReleaseSignal = "stack-lock-release"
+	// StackLockStatusQuery returns the StackLockState with the holder and the queue of a stack
+	StackLockStatusQuery = "status"
+)
+
+// StackLockGrant answers a StackLockRequest
+type StackLockGrant struct {
+	// SupersededBy is the workflow that replaced a queued run, which then exits without applying
+	SupersededBy string `json:",omitempty"`
+	// OldRevision is the base the run prunes from, the run takes the base of a run it superseded so that the
+	// changes of both are applied
+	OldRevision string `json:",omitempty"`
+}
+
+// How often the StackLock workflow checks that the holder is still running, a holder that was terminated never
+// releases the lock
+const stackLockHolderCheck = 5 * time.Minute
+
+// StackLock serialises the Infra runs of a stack: requests queue in order, a supersedable request replaces the
+// supersedable request still queued, the workflow completes once nothing holds or waits for the lock
+func StackLock(ctx workflow.Context, state activities.StackLockState) error {
+	logger := workflow.GetLogger(ctx)
+
+	if err := workflow.SetQueryHandler(ctx, StackLockStatusQuery, func() (activities.StackLockState, error) {
+		return state, nil
+	}); err != nil {
+		return err
+	}
+
+	requests := workflow.GetSignalChannel(ctx, activities.StackLockRequestSignal)
+	releases := workflow.GetSignalChannel(ctx, StackLockReleaseSignal)
+
+	sameRun := func(a *activities.StackLockRequest, b *activities.StackLockRequest) bool {
+		return a != nil && b != nil && a.WorkflowID == b.WorkflowID && a.RunID == b.RunID
+	}
+
+	enqueue := func(request *activities.StackLockRequest) {
+		// RequestStackLock is retried, a request can arrive twice
+		if sameRun(state.Holder, request) {
+			return
+		}
+		for _, queued := range state.Queue {
+			if sameRun(queued, request) {
+				return
+			}
+		}
+
+		request.Requested = workflow.Now(ctx)
+		if request.Supersedable {
+			var queue []*activities.StackLockRequest
+			for _, queued := range state.Queue {
+				if !queued.Supersedable {
+					queue = append(queue, queued)
+					continue
+				}
+				// Pruning from the older base covers the changes of both runs, a full run covers everything
+				if queued.OldRevision == "" || request.OldRevision == "" {
+					request.OldRevision = ""
+				} else {
+					request.OldRevision = queued.OldRevision
+				}
+				logger.Info("Queued run superseded", "workflowId", queued.WorkflowID, "revision", queued.Revision, "by", request.WorkflowID)
+				grant := StackLockGrant{SupersededBy: request.WorkflowID}
+				if err := workflow.SignalExternalWorkflow(ctx, queued.WorkflowID, queued.RunID, StackLockGrantSignal, grant).Get(ctx, nil); err != nil {
+					logger.Warn("Failed to notify superseded run", "workflowId", queued.WorkflowID, "error", err)
+				}
+			}
+			state.Queue = queue
+		}
+		state.Queue = append(state.Queue, request)
+		logger.Info("Run queued", "workflowId", request.WorkflowID, "revision", request.Revision, "position", len(state.Queue))
+	}
+
+	release := func(request *activities.StackLockRequest) {
+		if sameRun(state.Holder, request) {
+			logger.Info("Lock released", "workflowId", request.WorkflowID)
+			state.Holder = nil
+			return
+		}
+		for i, queued := range state.Queue {
+			if sameRun(queued, request) {
+				logger.Info("Queued run withdrawn", "workflowId", request.WorkflowID)
+				state.Queue = append(state.Queue[:i], state.Queue[i+1:]...)
+				return
+			}
+		}
+	}
+
+	// drain handles the signals already received, so that none is lost when the workflow completes or continues
+	drain := func() bool {
+		received := false
+		for {
+			var request activities.StackLockRequest
+			if requests.ReceiveAsync(&request) {
+				enqueue(&request)
+				received = true
+				continue
+			}
+			if releases.ReceiveAsync(&request) {
+				release(&request)
+				received = true
+				continue
+			}
+			return received
+		}
+	}
+
+	checkCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 1 * time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+
+	for {
+		for state.Holder == nil && len(state.Queue) > 0 {
+			next := state.Queue[0]
+			state.Queue = state.Queue[1:]
+			grant := StackLockGrant{OldRevision: next.OldRevision}
+			if err := workflow.SignalExternalWorkflow(ctx, next.WorkflowID, next.RunID, StackLockGrantSignal, grant).Get(ctx, nil); err != nil {
+				logger.Warn("Skipping queued run that is gone", "workflowId", next.WorkflowID, "error", err)
+				continue
+			}
+			logger.Info("Lock granted", "workflowId", next.WorkflowID, "revision", next.Revision, "oldRevision", next.OldRevision)
+			state.Holder = next
+		}
+
+		if state.Holder == nil && !drain() {
+			logger.Info("Stack lock idle", "stack", state.Stack)
+			return nil
+		}
+		if workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
+			drain()
+			return workflow.NewContinueAsNewError(ctx, StackLock, state)
+		}
+		if state.Holder == nil {
+			continue
+		}
+
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(requests, func(c workflow.ReceiveChannel, more bool) {
+			var request activities.StackLockRequest
+			c.Receive(ctx, &request)
+			enqueue(&request)
+		})
+		selector.AddReceive(releases, func(c workflow.ReceiveChannel, more bool) {
+			var request activities.StackLockRequest
+			c.Receive(ctx, &request)
+			release(&request)
+		})
+		selector.AddFuture(workflow.NewTimer(timerCtx, stackLockHolderCheck), func(f workflow.Future) {
+			if f.Get(ctx, nil) != nil {
+				return
+			}
+			holder := state.Holder
+			var running bool
+			if err := workflow.ExecuteActivity(checkCtx, activities.WorkflowRunning, holder.WorkflowID, holder.RunID).Get(ctx, &running); err != nil {
+				logger.Warn("Failed to check the lock holder", "workflowId", holder.WorkflowID, "error", err)
+				return
+			}
+			if !running && sameRun(state.Holder, holder) {
+				logger.Warn("Lock holder is no longer running, releasing", "workflowId", holder.WorkflowID)
+				state.Holder = nil
+			}
+		})
+		selector.Select(ctx)
+		cancelTimer()
+	}
+}
+
+// acquireStackLock queues a run changing a stack and waits until it holds the lock or was superseded, release
+// has to be called once the run is done, also when it was superseded or failed
+func acquireStackLock(ctx workflow.Context, stack string, request activities.StackLockRequest) (*StackLockGrant, func(), error) {
+	info := workflow.GetInfo(ctx)
+	request.WorkflowID = info.WorkflowExecution.ID
+	request.RunID = info.WorkflowExecution.RunID
+
+	lockID := activities.StackLockWorkflowID(stack)
+	release := func() {
+		// Release even when the run was cancelled
+		ctx, _ := workflow.NewDisconnectedContext(ctx)
+		if err := workflow.SignalExternalWorkflow(ctx, lockID, "", StackLockReleaseSignal, request).Get(ctx, nil); err != nil {
+			workflow.GetLogger(ctx).Warn("Failed to release stack lock", "stack", stack, "error", err)
+		}
+	}
+
+	requestCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 1 * time.Minute,
+	})
+	if err := workflow.ExecuteActivity(requestCtx, activities.RequestStackLock, stack, request).Get(ctx, nil); err != nil {
+		return nil, nil, err
+	}
+
+	var grant StackLockGrant
+	workflow.GetSignalChannel(ctx, StackLockGrantSignal).Receive(ctx, &grant)
+	if ctx.Err() != nil {
+		release()
+		return nil, nil, ctx.Err()
+	}
+	return &grant, release, nil
+}
@@ -0,0 +1,123 @@
+package workflows
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloudlab/controller/activities"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// grantStackLock answers the stack lock requests of apply runs at once, like an idle StackLock workflow
+func grantStackLock(env *testsuite.TestWorkflowEnvironment) {
+	env.OnActivity(activities.RequestStackLock, mock.Anything, mock.Anything, mock.Anything).Return(func(ctx context.Context, stack string, request activities.StackLockRequest) error {
+		env.SignalWorkflow(StackLockGrantSignal, StackLockGrant{OldRevision: request.OldRevision})
+		return nil
+	}).Maybe()
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, "", StackLockReleaseSignal, mock.Anything).Return(nil).Maybe()
+}
+
+type StackLockWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+	// grants records every grant sent, by workflow ID, in order
+	grants []string
+	sent   map[string]StackLockGrant
+}
+
+func (s *StackLockWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.env.SetTestTimeout(30 * time.Second)
+	s.grants = nil
+	s.sent = make(map[string]StackLockGrant)
+	s.env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, mock.Anything, StackLockGrantSignal, mock.Anything).Return(
+		func(namespace, workflowID, runID, signalName string, arg interface{}) error {
+			s.grants = append(s.grants, workflowID)
+			s.sent[workflowID] = arg.(StackLockGrant)
+			return nil
+		})
+}
+
+func (s *StackLockWorkflowTestSuite) AfterTest(suiteName, testName string) {
+	s.env.AssertExpectations(s.T())
+}
+
+func (s *StackLockWorkflowTestSuite) request(id string, oldRevision string, supersedable bool) activities.StackLockRequest {
+	return activities.StackLockRequest{WorkflowID: id, RunID: id + "-run", Revision: "master", OldRevision: oldRevision, Supersedable: supersedable}
+}
+
+func (s *StackLockWorkflowTestSuite) signal(delay time.Duration, name string, request activities.StackLockRequest) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(name, request)
+	}, delay)
+}
+
+func (s *StackLockWorkflowTestSuite) TestStackLock_QueuesAndSupersedes() {
+	s.signal(0, activities.StackLockRequestSignal, s.request("first", "", true))
+	s.signal(time.Second, activities.StackLockRequestSignal, s.request("targeted", "", false))
+	s.signal(2*time.Second, activities.StackLockRequestSignal, s.request("second", "HEAD~3", true))
+	s.signal(3*time.Second, activities.StackLockRequestSignal, s.request("third", "HEAD~1", true))
+	// RequestStackLock was retried
+	s.signal(3*time.Second, activities.StackLockRequestSignal, s.request("third", "HEAD~1", true))
+	s.env.RegisterDelayedCallback(func() {
+		value, err := s.env.QueryWorkflow(StackLockStatusQuery)
+		s.NoError(err)
+		var state activities.StackLockState
+		s.NoError(value.Get(&state))
+		s.Equal("first", state.Holder.WorkflowID)
+		s.Len(state.Queue, 2)
+		s.Equal("targeted", state.Queue[0].WorkflowID)
+		s.Equal("third", state.Queue[1].WorkflowID)
+		// Third prunes from the base of the run it superseded
+		s.Equal("HEAD~3", state.Queue[1].OldRevision)
+	}, 4*time.Second)
+	s.signal(5*time.Second, StackLockReleaseSignal, s.request("first", "", true))
+	s.signal(6*time.Second, StackLockReleaseSignal, s.request("targeted", "", false))
+	s.signal(7*time.Second, StackLockReleaseSignal, s.request("third", "", true))
+
+	s.env.ExecuteWorkflow(StackLock, activities.StackLockState{Stack: "production"})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"first", "second", "targeted", "third"}, s.grants)
+	s.Equal(StackLockGrant{SupersededBy: "third"}, s.sent["second"])
+	s.Equal(StackLockGrant{OldRevision: "HEAD~3"}, s.sent["third"])
+}
+
+func (s *StackLockWorkflowTestSuite) TestStackLock_FullRunSupersedesPrunedRun() {
+	s.signal(0, activities.StackLockRequestSignal, s.request("first", "", true))
+	s.signal(time.Second, activities.StackLockRequestSignal, s.request("pruned", "HEAD~1", true))
+	s.signal(2*time.Second, activities.StackLockRequestSignal, s.request("full", "", true))
+	s.signal(3*time.Second, StackLockReleaseSignal, s.request("first", "", true))
+	s.signal(4*time.Second, StackLockReleaseSignal, s.request("full", "", true))
+
+	s.env.ExecuteWorkflow(StackLock, activities.StackLockState{Stack: "production"})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"first", "pruned", "full"}, s.grants)
+	s.Equal(StackLockGrant{}, s.sent["full"])
+}
+
+func (s *StackLockWorkflowTestSuite) TestStackLock_ReleasesDeadHolder() {
+	s.env.OnActivity(activities.WorkflowRunning, mock.Anything, "first", "first-run").Return(false, nil).Once()
+	s.signal(0, activities.StackLockRequestSignal, s.request("first", "", true))
+	s.signal(time.Second, activities.StackLockRequestSignal, s.request("targeted", "", false))
+	s.signal(stackLockHolderCheck+time.Minute, StackLockReleaseSignal, s.request("targeted", "", false))
+
+	s.env.ExecuteWorkflow(StackLock, activities.StackLockState{Stack: "production"})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{"first", "targeted"}, s.grants)
+}
+
+func TestStackLockWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(StackLockWorkflowTestSuite))
+}
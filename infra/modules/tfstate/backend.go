@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// backend is the bucket holding the states of a stack, either on Cloudflare R2 or on any S3 endpoint like the
+// MinIO tfstate service of compose.yaml
+type backend struct {
+	bucket    string
+	endpoint  string
+	region    string
+	accessKey string
+	secretKey string
+	// Cloudflare account and API token, the API token is only needed to create R2 buckets through the Cloudflare API
+	accountID string
+	apiToken  string
+}
+
+func envOr(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// backendFlags registers the flags shared by every subcommand, the defaults come from the standard AWS
+// environment variables
+func backendFlags(flags *flag.FlagSet) *backend {
+	b := &backend{}
+	flags.StringVar(&b.bucket, "bucket", "", "State bucket name")
+	flags.StringVar(&b.endpoint, "endpoint", envOr("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "S3 endpoint URL, ignored for R2")
+	flags.StringVar(&b.region, "region", envOr("AWS_REGION", "AWS_DEFAULT_REGION"), "S3 region")
+	flags.StringVar(&b.accessKey, "access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key ID")
+	flags.StringVar(&b.secretKey, "secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret access key")
+	flags.StringVar(&b.accountID, "account-id", os.Getenv("CLOUDFLARE_ACCOUNT_ID"), "Cloudflare account ID")
+	flags.StringVar(&b.apiToken, "api-token", os.Getenv("CLOUDFLARE_API_TOKEN"), "Cloudflare API token")
+	return b
+}
+
+func (b *backend) validate() error {
+	if b.bucket == "" {
+		return errors.New("--bucket must be provided")
+	}
+	if b.endpoint == "" && b.accountID == "" {
+		return errors.New("--endpoint or --account-id must be provided")
+	}
+	return nil
+}
+
+// r2 reports whether the bucket is on Cloudflare R2 rather than a generic S3 endpoint, an account ID selects R2
+// even when the environment points the AWS endpoint elsewhere
+func (b *backend) r2() bool {
+	return b.accountID != ""
+}
+
+func (b *backend) cloudflare() (*cloudflare.API, *cloudflare.ResourceContainer, error) {
+	if b.apiToken == "" {
+		return nil, nil, errors.New("--api-token must be provided to manage R2 buckets")
+	}
+	api, err := cloudflare.NewWithAPIToken(b.apiToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Cloudflare API client: %w", err)
+	}
+	return api, cloudflare.AccountIdentifier(b.accountID), nil
+}
+
+// s3 returns a client for the S3 API of the backend, R2 serves it at the account endpoint
+func (b *backend) s3() (*minio.Client, error) {
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, errors.New("--access-key and --secret-key must be provided")
+	}
+
+	endpoint, region := b.endpoint, b.region
+	if b.r2() {
+		endpoint = fmt.Sprintf("https://%s.r2.cloudflarestorage.com", b.accountID)
+		if region == "" {
+			region = "auto"
+		}
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+
+	client, err := minio.New(endpointURL.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(b.accessKey, b.secretKey, ""),
+		Secure:       endpointURL.Scheme != "http",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client for %s: %w", endpoint, err)
+	}
+	return client, nil
+}
+
+// create creates the bucket if it is missing and reports whether it did, R2 buckets are created with the
+// Cloudflare API when there is a token, the S3 API works for both otherwise
+func (b *backend) create(ctx context.Context) (bool, error) {
+	if b.r2() && b.apiToken != "" {
+		api, account, err := b.cloudflare()
+		if err != nil {
+			return false, err
+		}
+		if _, err := api.GetR2Bucket(ctx, account, b.bucket); err == nil {
+			return false, nil
+		}
+		if _, err := api.CreateR2Bucket(ctx, account, cloudflare.CreateR2BucketParameters{Name: b.bucket}); err != nil {
+			return false, fmt.Errorf("failed to create bucket: %w", err)
+		}
+		return true, nil
+	}
+
+	client, err := b.s3()
+	if err != nil {
+		return false, err
+	}
+	exists, err := client.BucketExists(ctx, b.bucket)
+	if err != nil {
+		return false, fmt.Errorf("failed to check bucket: %w", err)
+	}
+	if exists {
+		return false, nil
+	}
+	if err := client.MakeBucket(ctx, b.bucket, minio.MakeBucketOptions{Region: b.region}); err != nil {
+		return false, fmt.Errorf("failed to create bucket: %w", err)
+	}
+	return true, nil
+}
@@ -2,47 +2,264 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
 
-	"github.com/cloudflare/cloudflare-go"
+	"github.com/minio/minio-go/v7"
 )
 
+// command registers its own flags next to the backend flags and returns what it runs once they are parsed
+type command struct {
+	description string
+	setup       func(flags *flag.FlagSet) func(ctx context.Context, b *backend) error
+}
+
+var commands = map[string]command{
+	"create": {
+		description: "Create the state bucket if it is missing",
+		setup:       func(flags *flag.FlagSet) func(context.Context, *backend) error { return create },
+	},
+	"verify": {
+		description: "Check that the bucket is reachable with the given credentials",
+		setup:       func(flags *flag.FlagSet) func(context.Context, *backend) error { return verify },
+	},
+	"enable-versioning": {
+		description: "Keep every version of the states written to the bucket",
+		setup:       func(flags *flag.FlagSet) func(context.Context, *backend) error { return enableVersioning },
+	},
+	"list": {
+		description: "List the state keys of the stack",
+		setup: func(flags *flag.FlagSet) func(context.Context, *backend) error {
+			prefix := flags.String("prefix", "", "Only list keys under this module path")
+			return func(ctx context.Context, b *backend) error {
+				return list(ctx, b, *prefix)
+			}
+		},
+	},
+	"show": {
+		description: "Show the resources in a state key",
+		setup: func(flags *flag.FlagSet) func(context.Context, *backend) error {
+			key := flags.String("key", "", "State key")
+			return func(ctx context.Context, b *backend) error {
+				return show(ctx, b, *key)
+			}
+		},
+	},
+	"delete": {
+		description: "Delete a state key",
+		setup: func(flags *flag.FlagSet) func(context.Context, *backend) error {
+			key := flags.String("key", "", "State key")
+			confirm := flags.String("confirm", "", "Must be set to the state key again")
+			return func(ctx context.Context, b *backend) error {
+				return deleteState(ctx, b, *key, *confirm)
+			}
+		},
+	},
+	"migrate": {
+		description: "Copy the state keys of the stack to another backend",
+		setup: func(flags *flag.FlagSet) func(context.Context, *backend) error {
+			target := targetFlags(flags)
+			prefix := flags.String("prefix", "", "Only migrate keys under this module path")
+			name := flags.String("to-key-name", "", "State file name in the target like tfstate.json, defaults to the source name")
+			dryRun := flags.Bool("dry-run", false, "Only report what would be migrated")
+			return func(ctx context.Context, source *backend) error {
+				target.inherit(source)
+				return migrate(ctx, source, target, *prefix, *name, *dryRun)
+			}
+		},
+	},
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command\n", os.Args[0])
+}
+
 func main() {
-	var apiToken, accountID, bucket string
-	flag.StringVar(&apiToken, "api-token", "", "Cloudflare account ID")
-	flag.StringVar(&accountID, "account-id", "", "Cloudflare account ID")
-	flag.StringVar(&bucket, "bucket", "", "Cloudflare R2 bucket name")
-	flag.Parse()
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	b := backendFlags(flags)
+	run := cmd.setup(flags)
+	flags.Parse(os.Args[2:])
 
-	if apiToken == "" || accountID == "" || bucket == "" {
-		log.Fatal("--api-token, --account-id and --bucket must be provided")
+	if err := b.validate(); err != nil {
+		log.Fatal(err)
 	}
+	if err := run(context.Background(), b); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func create(ctx context.Context, b *backend) error {
+	created, err := b.create(ctx)
+	if err != nil {
+		return err
+	}
+	if !created {
+		log.Printf("bucket %q already exists\n", b.bucket)
+		return nil
+	}
+	log.Printf("created bucket %q\n", b.bucket)
+	return nil
+}
 
-	resourceContainer := cloudflare.AccountIdentifier(accountID)
-	ctx := context.Background()
+func verify(ctx context.Context, b *backend) error {
+	if b.r2() && b.apiToken != "" {
+		api, account, err := b.cloudflare()
+		if err != nil {
+			return err
+		}
+		if _, err := api.GetR2Bucket(ctx, account, b.bucket); err != nil {
+			return fmt.Errorf("failed to get bucket with the Cloudflare API: %w", err)
+		}
+		log.Printf("bucket %q found with the Cloudflare API\n", b.bucket)
+		if b.accessKey == "" {
+			return nil
+		}
+	}
 
-	api, err := cloudflare.NewWithAPIToken(apiToken)
+	client, err := b.s3()
 	if err != nil {
-		log.Fatalf("failed to create Cloudflare API client: %v", err)
+		return err
+	}
+	exists, err := client.BucketExists(ctx, b.bucket)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", client.EndpointURL(), err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %q does not exist at %s", b.bucket, client.EndpointURL())
+	}
+	for object := range client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{MaxKeys: 1}) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list bucket %q: %w", b.bucket, object.Err)
+		}
+		break
 	}
 
-	existingBucket, err := api.GetR2Bucket(ctx, resourceContainer, bucket)
-	if err == nil {
-		log.Printf("bucket %q already exists: %+v\n", bucket, existingBucket)
-		return
+	versioning := "unknown"
+	if config, err := client.GetBucketVersioning(ctx, b.bucket); err == nil {
+		versioning = "disabled"
+		if config.Enabled() {
+			versioning = "enabled"
+		}
 	}
+	log.Printf("bucket %q is readable at %s, versioning %s\n", b.bucket, client.EndpointURL(), versioning)
+	return nil
+}
 
-	createdBucket, err := api.CreateR2Bucket(
-		ctx,
-		resourceContainer,
-		cloudflare.CreateR2BucketParameters{
-			Name: bucket,
-		},
-	)
+func enableVersioning(ctx context.Context, b *backend) error {
+	if b.r2() {
+		return errors.New("R2 does not support bucket versioning")
+	}
+
+	client, err := b.s3()
 	if err != nil {
-		log.Fatalf("failed to create bucket: %v", err)
+		return err
 	}
+	if err := client.EnableVersioning(ctx, b.bucket); err != nil {
+		return fmt.Errorf("failed to enable versioning: %w", err)
+	}
+	log.Printf("versioning enabled on bucket %q\n", b.bucket)
+	return nil
+}
+
+func list(ctx context.Context, b *backend, prefix string) error {
+	client, err := b.s3()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSIZE\tMODIFIED")
+	for object := range client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list bucket %q: %w", b.bucket, object.Err)
+		}
+		if !isStateKey(object.Key) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", object.Key, object.Size, object.LastModified.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return w.Flush()
+}
 
-	log.Printf("created bucket: %+v\n", createdBucket)
+func show(ctx context.Context, b *backend, key string) error {
+	if key == "" {
+		return errors.New("--key must be provided")
+	}
+	client, err := b.s3()
+	if err != nil {
+		return err
+	}
+
+	object, err := client.GetObject(ctx, b.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	s, err := parseState(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	fmt.Printf("# lineage %s, serial %d, terraform %s\n", s.Lineage, s.Serial, s.TerraformVersion)
+	for _, address := range s.addresses() {
+		fmt.Println(address)
+	}
+	return nil
+}
+
+func deleteState(ctx context.Context, b *backend, key string, confirm string) error {
+	if key == "" {
+		return errors.New("--key must be provided")
+	}
+	if confirm != key {
+		return fmt.Errorf("deleting %s requires --confirm to be set to the key", key)
+	}
+	client, err := b.s3()
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to find %s: %w", key, err)
+	}
+	if err := client.RemoveObject(ctx, b.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+
+	if config, err := client.GetBucketVersioning(ctx, b.bucket); err == nil && config.Enabled() {
+		log.Printf("deleted %s, its previous versions are kept\n", key)
+		return nil
+	}
+	log.Printf("deleted %s\n", key)
+	return nil
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// targetFlags registers the flags of the backend states are migrated to, flags that are not given are taken
+// from the source backend by inherit
+func targetFlags(flags *flag.FlagSet) *backend {
+	b := &backend{}
+	flags.StringVar(&b.bucket, "to-bucket", "", "Target state bucket name")
+	flags.StringVar(&b.endpoint, "to-endpoint", "", "Target S3 endpoint URL")
+	flags.StringVar(&b.region, "to-region", "", "Target S3 region")
+	flags.StringVar(&b.accessKey, "to-access-key", "", "Target S3 access key ID")
+	flags.StringVar(&b.secretKey, "to-secret-key", "", "Target S3 secret access key")
+	flags.StringVar(&b.accountID, "to-account-id", "", "Target Cloudflare account ID")
+	return b
+}
+
+// inherit fills the target flags that were not given from the source backend, the endpoint, region and account
+// together so that naming either one switches to another service
+func (b *backend) inherit(source *backend) {
+	if b.bucket == "" {
+		b.bucket = source.bucket
+	}
+	if b.endpoint == "" && b.accountID == "" {
+		b.endpoint, b.accountID = source.endpoint, source.accountID
+		if b.region == "" {
+			b.region = source.region
+		}
+	}
+	if b.accessKey == "" && b.secretKey == "" {
+		b.accessKey, b.secretKey = source.accessKey, source.secretKey
+	}
+}
+
+type migrateAction string
+
+const (
+	// migrateCopy writes a state the target does not have yet
+	migrateCopy migrateAction = "copy"
+	// migrateUpdate overwrites an older version of the same state, left by an earlier migration
+	migrateUpdate migrateAction = "update"
+	// migrateDone skips a state the target already has, which makes an interrupted migration resumable
+	migrateDone migrateAction = "done"
+	// migrateConflict refuses to touch a target that is not an older copy of the source
+	migrateConflict migrateAction = "conflict"
+)
+
+// planMigration decides what to do with a state key, target is nil when the target has no such key
+func planMigration(source *state, sourceData []byte, target *state, targetData []byte) (migrateAction, string) {
+	if target == nil {
+		return migrateCopy, fmt.Sprintf("serial %d", source.Serial)
+	}
+	if target.Lineage != source.Lineage {
+		return migrateConflict, fmt.Sprintf("target has lineage %s, source has lineage %s", target.Lineage, source.Lineage)
+	}
+	switch {
+	case target.Serial > source.Serial:
+		return migrateConflict, fmt.Sprintf("target is ahead at serial %d, source is at serial %d", target.Serial, source.Serial)
+	case target.Serial < source.Serial:
+		return migrateUpdate, fmt.Sprintf("serial %d to %d", target.Serial, source.Serial)
+	case !bytes.Equal(bytes.TrimSpace(targetData), bytes.TrimSpace(sourceData)):
+		return migrateConflict, fmt.Sprintf("target differs from source at the same serial %d", source.Serial)
+	default:
+		return migrateDone, fmt.Sprintf("serial %d", source.Serial)
+	}
+}
+
+// targetKey renames the state file of a key when the target uses another naming, like tfstate.json for
+// terraform.tfstate
+func targetKey(key string, name string) string {
+	if name == "" {
+		return key
+	}
+	return path.Join(path.Dir(key), name)
+}
+
+func readState(ctx context.Context, client *minio.Client, bucket string, key string) (*state, []byte, error) {
+	object, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	s, err := parseState(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return s, data, nil
+}
+
+// locked reports whether terraform holds the S3 lock file of a state, migrating a state that is being written
+// would copy a version that is about to change
+func locked(ctx context.Context, client *minio.Client, bucket string, key string) (bool, error) {
+	_, err := client.StatObject(ctx, bucket, key+".tflock", minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check the lock of %s: %w", key, err)
+}
+
+// migrate copies every state key of the stack to the target backend. States the target already has are skipped
+// so that a migration can be run again after it was interrupted, a target that is ahead of or unrelated to the
+// source is left alone and reported as a conflict.
+func migrate(ctx context.Context, source *backend, target *backend, prefix string, name string, dryRun bool) error {
+	if err := target.validate(); err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("--to-key-name %q must be a file name", name)
+	}
+	from, err := source.s3()
+	if err != nil {
+		return err
+	}
+	to, err := target.s3()
+	if err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	if from.EndpointURL().Host == to.EndpointURL().Host && source.bucket == target.bucket {
+		return errors.New("source and target are the same bucket")
+	}
+
+	exists, err := to.BucketExists(ctx, target.bucket)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", to.EndpointURL(), err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %q does not exist at %s, run the create command for it first", target.bucket, to.EndpointURL())
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tTARGET\tACTION\tDETAIL")
+	counts := map[migrateAction]int{}
+	for object := range from.ListObjects(ctx, source.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list bucket %q: %w", source.bucket, object.Err)
+		}
+		if !isStateKey(object.Key) {
+			continue
+		}
+		key, toKey := object.Key, targetKey(object.Key, name)
+
+		action, detail, err := migrateKey(ctx, from, source.bucket, key, to, target.bucket, toKey, dryRun)
+		if err != nil {
+			w.Flush()
+			return err
+		}
+		counts[action]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, toKey, action, detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	verb := "migrated"
+	if dryRun {
+		verb = "to migrate"
+	}
+	log.Printf("%d %s, %d updated, %d already done, %d conflicts\n", counts[migrateCopy], verb, counts[migrateUpdate], counts[migrateDone], counts[migrateConflict])
+	if counts[migrateConflict] > 0 {
+		return errors.New("some states were not migrated, resolve the conflicts and run the migration again")
+	}
+	return nil
+}
+
+func migrateKey(ctx context.Context, from *minio.Client, fromBucket string, key string, to *minio.Client, toBucket string, toKey string, dryRun bool) (migrateAction, string, error) {
+	isLocked, err := locked(ctx, from, fromBucket, key)
+	if err != nil {
+		return "", "", err
+	}
+	if isLocked {
+		return migrateConflict, "source is locked", nil
+	}
+
+	source, sourceData, err := readState(ctx, from, fromBucket, key)
+	if err != nil {
+		return "", "", err
+	}
+	if source == nil {
+		return migrateConflict, "source was deleted during the migration", nil
+	}
+	target, targetData, err := readState(ctx, to, toBucket, toKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	action, detail := planMigration(source, sourceData, target, targetData)
+	if dryRun || (action != migrateCopy && action != migrateUpdate) {
+		return action, detail, nil
+	}
+
+	if _, err := to.PutObject(ctx, toBucket, toKey, bytes.NewReader(sourceData), int64(len(sourceData)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	}); err != nil {
+		return "", "", fmt.Errorf("failed to write %s: %w", toKey, err)
+	}
+
+	// Read the copy back, a write that did not land must not count as migrated
+	written, _, err := readState(ctx, to, toBucket, toKey)
+	if err != nil {
+		return "", "", err
+	}
+	if written == nil || written.Lineage != source.Lineage || written.Serial != source.Serial {
+		return "", "", fmt.Errorf("%s was not written as serial %d of lineage %s", toKey, source.Serial, source.Lineage)
+	}
+	return action, detail, nil
+}
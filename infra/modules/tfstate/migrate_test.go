@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPlanMigration(t *testing.T) {
+	sourceData := []byte(`{"version": 4, "serial": 7, "lineage": "b6e1c6a4", "resources": []}`)
+	source, err := parseState(sourceData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		targetData string
+		expected   migrateAction
+	}{
+		{
+			name:     "missing in the target",
+			expected: migrateCopy,
+		},
+		{
+			name:       "older copy of an earlier migration",
+			targetData: `{"version": 4, "serial": 5, "lineage": "b6e1c6a4", "resources": []}`,
+			expected:   migrateUpdate,
+		},
+		{
+			name:       "already migrated",
+			targetData: string(sourceData) + "\n",
+			expected:   migrateDone,
+		},
+		{
+			name:       "changed in the target at the same serial",
+			targetData: `{"version": 4, "serial": 7, "lineage": "b6e1c6a4", "resources": [{"mode": "managed", "type": "null_resource", "name": "x"}]}`,
+			expected:   migrateConflict,
+		},
+		{
+			name:       "applied in the target since",
+			targetData: `{"version": 4, "serial": 9, "lineage": "b6e1c6a4", "resources": []}`,
+			expected:   migrateConflict,
+		},
+		{
+			name:       "unrelated state",
+			targetData: `{"version": 4, "serial": 1, "lineage": "0f3d2e1c", "resources": []}`,
+			expected:   migrateConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var target *state
+			if tc.targetData != "" {
+				if target, err = parseState([]byte(tc.targetData)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			action, detail := planMigration(source, sourceData, target, []byte(tc.targetData))
+			if action != tc.expected {
+				t.Errorf("planMigration() = %s (%s), want %s", action, detail, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTargetKey(t *testing.T) {
+	if got := targetKey("cluster/terraform.tfstate", ""); got != "cluster/terraform.tfstate" {
+		t.Errorf("targetKey() = %q without a name", got)
+	}
+	if got := targetKey("metal/bootstrap/terraform.tfstate", "tfstate.json"); got != "metal/bootstrap/tfstate.json" {
+		t.Errorf("targetKey() = %q, want metal/bootstrap/tfstate.json", got)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	source := &backend{bucket: "tfstate", endpoint: "http://localhost:9000", region: "eu-west-1", accessKey: "minioadmin", secretKey: "minioadmin"}
+
+	// Renaming the bucket on the same service
+	target := &backend{bucket: "tfstate-local"}
+	target.inherit(source)
+	if *target != (backend{bucket: "tfstate-local", endpoint: source.endpoint, region: source.region, accessKey: "minioadmin", secretKey: "minioadmin"}) {
+		t.Errorf("inherit() = %+v", target)
+	}
+
+	// Moving to R2 takes nothing of MinIO but the bucket name
+	target = &backend{accountID: "0123456789abcdef", accessKey: "r2-key", secretKey: "r2-secret"}
+	target.inherit(source)
+	if *target != (backend{bucket: "tfstate", accountID: "0123456789abcdef", accessKey: "r2-key", secretKey: "r2-secret"}) {
+		t.Errorf("inherit() = %+v", target)
+	}
+	if !target.r2() {
+		t.Error("target with an account ID is not R2")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// isStateKey reports whether an object is a state file, terragrunt stores one per module under the module path:
+// terraform.tfstate for the local stack, tfstate.json for production
+func isStateKey(key string) bool {
+	name := path.Base(key)
+	return strings.HasSuffix(name, ".tfstate") || name == "tfstate.json"
+}
+
+type state struct {
+	Version          int             `json:"version"`
+	TerraformVersion string          `json:"terraform_version"`
+	Serial           int64           `json:"serial"`
+	Lineage          string          `json:"lineage"`
+	Resources        []stateResource `json:"resources"`
+}
+
+type stateResource struct {
+	Module    string `json:"module"`
+	Mode      string `json:"mode"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Instances []struct {
+		// IndexKey is a number for count and a string for for_each, its JSON is the index syntax of an address
+		IndexKey json.RawMessage `json:"index_key"`
+	} `json:"instances"`
+}
+
+func parseState(data []byte) (*state, error) {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+	if s.Version != 4 {
+		return nil, fmt.Errorf("unsupported state version %d", s.Version)
+	}
+	return &s, nil
+}
+
+// addresses returns the address of every resource instance, as terraform state list prints them
+func (s *state) addresses() []string {
+	var addresses []string
+	for _, resource := range s.Resources {
+		address := resource.Type + "." + resource.Name
+		if resource.Mode == "data" {
+			address = "data." + address
+		}
+		if resource.Module != "" {
+			address = resource.Module + "." + address
+		}
+		if len(resource.Instances) == 0 {
+			addresses = append(addresses, address)
+		}
+		for _, instance := range resource.Instances {
+			if len(instance.IndexKey) == 0 {
+				addresses = append(addresses, address)
+				continue
+			}
+			addresses = append(addresses, fmt.Sprintf("%s[%s]", address, instance.IndexKey))
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsStateKey(t *testing.T) {
+	for key, expected := range map[string]bool{
+		"cluster/terraform.tfstate":      true,
+		"metal/bootstrap/tfstate.json":   true,
+		"cluster/terraform.tfstate.lock": false,
+		"cluster/backend.tf":             false,
+	} {
+		if got := isStateKey(key); got != expected {
+			t.Errorf("isStateKey(%q) = %v, want %v", key, got, expected)
+		}
+	}
+}
+
+func TestStateAddresses(t *testing.T) {
+	s, err := parseState([]byte(`{
+		"version": 4,
+		"terraform_version": "1.9.0",
+		"serial": 7,
+		"lineage": "b6e1c6a4",
+		"resources": [
+			{"mode": "managed", "type": "oci_core_instance", "name": "node", "instances": [{"index_key": 0}, {"index_key": 1}]},
+			{"module": "module.network", "mode": "managed", "type": "oci_core_vcn", "name": "main", "instances": [{}]},
+			{"mode": "data", "type": "oci_identity_availability_domains", "name": "ads", "instances": [{}]},
+			{"mode": "managed", "type": "cloudflare_record", "name": "dns", "instances": [{"index_key": "www"}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"oci_core_instance.node[0]",
+		"oci_core_instance.node[1]",
+		"module.network.oci_core_vcn.main",
+		"data.oci_identity_availability_domains.ads",
+		`cloudflare_record.dns["www"]`,
+	}
+	if got := s.addresses(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("addresses() = %q, want %q", got, expected)
+	}
+
+	if _, err := parseState([]byte(`{"version": 3}`)); err == nil {
+		t.Error("parseState accepted state version 3")
+	}
+}
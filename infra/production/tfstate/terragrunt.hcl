@@ -9,7 +9,7 @@ terraform {
   before_hook "bootstrap_tfstate" {
     commands = ["init", "plan", "apply"]
     execute = [
-      "go", "run", ".",
+      "go", "run", ".", "create",
       "--api-token=${include.root.locals.secrets.cloudflare_tfstate_api_token}",
       "--account-id=${include.root.locals.secrets.cloudflare_account_id}",
       "--bucket=tfstate-${include.root.locals.env}",